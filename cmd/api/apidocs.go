@@ -3,6 +3,7 @@ package main
 import (
 	_ "embed"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -17,23 +18,42 @@ var apiDocsIndex []byte
 var apiDocsLogo []byte
 
 func (app *application) RouteAPIDocs(r chi.Router) {
+	// The embedded files never change, so they are compressed once here rather than on every
+	// request.
+	yaml := newPrecompressedAsset(apiDocsYAML, "text/yaml")
+	logo := newPrecompressedAsset(apiDocsLogo, "image/png")
+	index := newPrecompressedAsset(apiDocsIndex, "text/html")
+
 	r.Get("/static/swagger.yaml", func(w http.ResponseWriter, r *http.Request) {
-		app.HandleStaticFile(w, r, apiDocsYAML, "text/yaml")
+		app.HandleStaticFile(w, r, yaml)
 	})
 	r.Get("/static/logo.png", func(w http.ResponseWriter, r *http.Request) {
-		app.HandleStaticFile(w, r, apiDocsLogo, "image/png")
+		app.HandleStaticFile(w, r, logo)
 	})
 	r.Get("/apidocs", func(w http.ResponseWriter, r *http.Request) {
-		app.HandleStaticFile(w, r, apiDocsIndex, "text/html")
+		app.HandleStaticFile(w, r, index)
 	})
 }
 
-// HandleStaticFile is a helper function to serve static files
-func (app *application) HandleStaticFile(w http.ResponseWriter, r *http.Request, file []byte, contentType string) {
-	w.Header().Set("Content-Type", contentType)
+// HandleStaticFile is a helper function to serve static files, in the precompressed variant the
+// client accepts if there is one
+func (app *application) HandleStaticFile(w http.ResponseWriter, r *http.Request, file *precompressedAsset) {
+	w.Header().Set("Content-Type", file.contentType)
 	w.Header().Set("Cache-Control", "no-store")
 
-	_, err := w.Write(file)
+	body := file.body
+	if len(file.encoded) > 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoded, ok := file.encoded[encoding]; ok {
+			w.Header().Set("Content-Encoding", encoding)
+			body = encoded
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+
+	_, err := w.Write(body)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
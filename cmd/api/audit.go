@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	"github.com/yanglyu520/movies-golang-web-api/internal/validator"
+)
+
+// maxActorLength caps the length of the X-Actor header recorded in the audit log.
+const maxActorLength = 200
+
+// auditedModels returns the models to make changes with on behalf of r, so that they are
+// attributed to the actor named in its X-Actor header in the audit log.
+func (app *application) auditedModels(r *http.Request) data.Models {
+	actor := r.Header.Get("X-Actor")
+	if len(actor) > maxActorLength {
+		actor = actor[:maxActorLength]
+	}
+
+	// RemoteAddr has already been replaced with the client address by the RealIP middleware.
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+
+	return app.models.WithAuditor(data.Auditor{
+		Actor:     actor,
+		RequestID: middleware.GetReqID(r.Context()),
+		RemoteIP:  remoteIP,
+	})
+}
+
+// HandleAuditList is the handler for the audit log endpoint
+//
+//	@Summary		Get the audit log
+//	@Description	Get the recorded movie changes, newest first. Every entry holds the actor (from
+//	@Description	the X-Actor header of the change), request id, remote IP and the before and after
+//	@Description	value of every changed field.
+//	@Tags			audit
+//	@Param			entity_id	query	int		false	"movie ID"
+//	@Param			actor		query	string	false	"actor"
+//	@Param			from		query	string	false	"earliest change time (RFC 3339, inclusive)"
+//	@Param			to			query	string	false	"latest change time (RFC 3339, exclusive)"
+//	@Param			page		query	int		false	"page"
+//	@Param			page_size	query	int		false	"page size"
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
+//	@Success		200	{object}	data.AuditList
+//	@Failure		422	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/v1/audit [get]
+func (app *application) HandleAuditList(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.AuditFilters
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	input.EntityID = int64(app.readInt(qs, "entity_id", 0, v))
+	input.Actor = app.readString(qs, "actor", "")
+	input.From = app.readTime(qs, "from", v)
+	input.To = app.readTime(qs, "to", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = "-id"
+	input.Filters.SortSafelist = []string{"-id"}
+
+	v.Check(input.EntityID >= 0, "entity_id", "must not be negative")
+	v.Check(input.From.IsZero() || input.To.IsZero() || input.From.Before(input.To), "to", "must be later than from")
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.Audit.GetAll(input.AuditFilters, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, data.AuditList{Entries: entries, Metadata: metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// contentEncodings are the supported response encodings, in order of preference when a client
+// accepts several of them equally.
+var contentEncodings = []string{"gzip", "deflate"}
+
+// incompressibleTypes are media types, or media type prefixes ending in "/", whose content is
+// already compressed and would only grow by being compressed again.
+var incompressibleTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"video/",
+	"audio/",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() any {
+		zw, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return zw
+	}}
+	flateWriters = sync.Pool{New: func() any {
+		zw, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return zw
+	}}
+)
+
+// compressor is the common interface of gzip.Writer and flate.Writer.
+type compressor interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+	Flush() error
+}
+
+func newCompressor(encoding string, w io.Writer) compressor {
+	var zw compressor
+	switch encoding {
+	case "gzip":
+		zw = gzipWriters.Get().(*gzip.Writer)
+	default:
+		zw = flateWriters.Get().(*flate.Writer)
+	}
+	zw.Reset(w)
+	return zw
+}
+
+func releaseCompressor(zw compressor) {
+	switch zw := zw.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(zw)
+	case *flate.Writer:
+		flateWriters.Put(zw)
+	}
+}
+
+// negotiateEncoding picks the content encoding to use for a response from an Accept-Encoding
+// header. It returns an empty string when the response should not be compressed.
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		quality := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			quality = q
+		}
+		qualities[coding] = quality
+	}
+
+	best, bestQuality := "", 0.0
+	for _, encoding := range contentEncodings {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// compressible reports whether a response of the given Content-Type is worth compressing.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range incompressibleTypes {
+		if mediaType == t || strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// encodedETag is the ETag of the response tagged etag when compressed with encoding. A compressed
+// body differs from the identity body, so it cannot share its strong ETag; the encoding is added
+// to the opaque tag, as in "…-gzip".
+func encodedETag(etag, encoding string) string {
+	opaque, ok := strings.CutSuffix(etag, `"`)
+	if !ok {
+		return etag
+	}
+	return opaque + "-" + encoding + `"`
+}
+
+// identityETags removes the content encodings added by encodedETag from the tags of an
+// If-None-Match header value.
+func identityETags(header string) string {
+	tags := strings.Split(header, ",")
+	for i, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		for _, encoding := range contentEncodings {
+			if opaque, ok := strings.CutSuffix(tag, "-"+encoding+`"`); ok {
+				tag = opaque + `"`
+				break
+			}
+		}
+		tags[i] = tag
+	}
+	return strings.Join(tags, ", ")
+}
+
+// compress is a middleware compressing response bodies with gzip or deflate, as negotiated with
+// the Accept-Encoding request header. Responses smaller than the configured minimum size,
+// responses of an incompressible type and responses that already carry a Content-Encoding are
+// sent as they are. The ETag of a compressed response names its encoding.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       negotiateEncoding(r.Header.Get("Accept-Encoding")),
+			minSize:        app.cfg.compress.minSize,
+		}
+		if r.Method == http.MethodHead {
+			cw.encoding = ""
+		}
+
+		// Compressed responses carry their own ETag, see encodedETag. The handler only knows the
+		// ETags of the identity responses, so the request is passed on with the encoding taken
+		// off the tags the client holds.
+		if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && cw.encoding != "" {
+			cw.ifNoneMatch = ifNoneMatch
+			r = r.Clone(r.Context())
+			r.Header.Set("If-None-Match", identityETags(ifNoneMatch))
+		}
+
+		next.ServeHTTP(cw, r)
+
+		// Close is deliberately not deferred: after a panic the buffered response must not be
+		// sent, so that the recoverer can still respond with an error.
+		if err := cw.Close(); err != nil {
+			app.logError(r, err)
+		}
+	})
+}
+
+// compressWriter buffers the start of a response until it knows whether the response is large
+// enough to be compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	// ifNoneMatch is the If-None-Match header of the request as sent by the client.
+	ifNoneMatch string
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	zw          compressor
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.status = status
+	cw.wroteHeader = true
+
+	// Informational responses are sent right away, and some responses never carry a body.
+	switch {
+	case status < http.StatusOK:
+		cw.wroteHeader = false
+		cw.ResponseWriter.WriteHeader(status)
+	case status == http.StatusNotModified:
+		// A 304 carries the Vary header the full response would have had, and the ETag of the
+		// compressed response when that is what the client holds.
+		cw.Header().Add("Vary", "Accept-Encoding")
+		if etag := cw.Header().Get("ETag"); etag != "" && cw.encoding != "" {
+			if encoded := encodedETag(etag, cw.encoding); etagMatches(cw.ifNoneMatch, encoded, true) {
+				cw.Header().Set("ETag", encoded)
+			}
+		}
+		cw.decided = true
+		cw.ResponseWriter.WriteHeader(status)
+	case status == http.StatusNoContent:
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.minSize {
+			return len(p), nil
+		}
+		if err := cw.start(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if cw.zw != nil {
+		return cw.zw.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide sends the response header, compressing the body from here on if compress is true and
+// the response qualifies.
+func (cw *compressWriter) decide(compress bool) {
+	cw.decided = true
+
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	if h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		// The representation depends on Accept-Encoding even when this particular response
+		// is too small to be compressed.
+		h.Add("Vary", "Accept-Encoding")
+
+		if compress && cw.encoding != "" {
+			h.Set("Content-Encoding", cw.encoding)
+			h.Del("Content-Length")
+			if etag := h.Get("ETag"); etag != "" {
+				h.Set("ETag", encodedETag(etag, cw.encoding))
+			}
+			cw.zw = newCompressor(cw.encoding, cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// start decides how the response is sent and writes out the buffered start of its body.
+func (cw *compressWriter) start(compress bool) error {
+	cw.decide(compress)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if cw.zw != nil {
+		_, err := cw.zw.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// Flush sends whatever has been written so far. A streamed response is compressed even if it
+// has not reached the minimum size yet, since its final size is unknown.
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		if err := cw.start(true); err != nil {
+			return
+		}
+	}
+	if cw.zw != nil {
+		if err := cw.zw.Flush(); err != nil {
+			return
+		}
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close sends a response that was still buffered and terminates the compressed stream.
+func (cw *compressWriter) Close() error {
+	if cw.wroteHeader && !cw.decided {
+		if err := cw.start(false); err != nil {
+			return err
+		}
+	}
+	if cw.zw == nil {
+		return nil
+	}
+
+	err := cw.zw.Close()
+	releaseCompressor(cw.zw)
+	cw.zw = nil
+	return err
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// precompressedAsset is a static file with its compressed variants computed up front.
+type precompressedAsset struct {
+	contentType string
+	body        []byte
+	encoded     map[string][]byte
+}
+
+// newPrecompressedAsset compresses body with every supported content encoding, keeping only the
+// variants that are actually smaller.
+func newPrecompressedAsset(body []byte, contentType string) *precompressedAsset {
+	asset := &precompressedAsset{contentType: contentType, body: body, encoded: map[string][]byte{}}
+	if !compressible(contentType) {
+		return asset
+	}
+
+	for _, encoding := range contentEncodings {
+		var buf bytes.Buffer
+		zw := newCompressor(encoding, &buf)
+		_, err := zw.Write(body)
+		if err == nil {
+			err = zw.Close()
+		}
+		releaseCompressor(zw)
+		if err == nil && buf.Len() < len(body) {
+			asset.encoded[encoding] = buf.Bytes()
+		}
+	}
+	return asset
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"GZIP", "gzip"},
+		{"gzip, deflate", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip; q=0.8, deflate;q=0.9", "deflate"},
+		{"gzip;q=0", ""},
+		{"*", "gzip"},
+		{"*;q=0.5, gzip;q=0", "deflate"},
+		{"br, identity", ""},
+		{"gzip;q=abc, deflate", "deflate"},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.acceptEncoding); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q; want %q", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
+
+func TestEncodedETag(t *testing.T) {
+	tests := []struct {
+		etag, encoding, want string
+	}{
+		{`"abc"`, "gzip", `"abc-gzip"`},
+		{`W/"abc"`, "deflate", `W/"abc-deflate"`},
+		{`abc`, "gzip", `abc`},
+	}
+
+	for _, tt := range tests {
+		if got := encodedETag(tt.etag, tt.encoding); got != tt.want {
+			t.Errorf("encodedETag(%q, %q) = %q; want %q", tt.etag, tt.encoding, got, tt.want)
+		}
+	}
+}
+
+func TestIdentityETags(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{`"abc-gzip"`, `"abc"`},
+		{`"abc-deflate", W/"def-gzip"`, `"abc", W/"def"`},
+		{`"abc"`, `"abc"`},
+		{`"abc-br"`, `"abc-br"`},
+		{`*`, `*`},
+	}
+
+	for _, tt := range tests {
+		if got := identityETags(tt.header); got != tt.want {
+			t.Errorf("identityETags(%q) = %q; want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+// newCompressTestHandler serves body with the given content type through the compress
+// middleware, tagged with a strong ETag and answering If-None-Match like the API handlers do.
+func newCompressTestHandler(minSize int, contentType, body string) http.Handler {
+	app := newTestApplication()
+	app.cfg.compress.minSize = minSize
+
+	return app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.notModified(w, r, `"v1"`) {
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", contentType)
+		io.WriteString(w, body)
+	}))
+}
+
+func serveCompressTest(h http.Handler, method string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/", nil)
+	r.Header = header
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCompressMinSize(t *testing.T) {
+	const minSize = 100
+	gzipHeader := http.Header{"Accept-Encoding": {"gzip"}}
+
+	tests := []struct {
+		name         string
+		method       string
+		header       http.Header
+		contentType  string
+		size         int
+		wantEncoding string
+	}{
+		{"below the minimum", http.MethodGet, gzipHeader, "application/json", minSize - 1, ""},
+		{"at the minimum", http.MethodGet, gzipHeader, "application/json", minSize, "gzip"},
+		{"large", http.MethodGet, gzipHeader, "application/json", 10 * minSize, "gzip"},
+		{"not accepted", http.MethodGet, http.Header{}, "application/json", 10 * minSize, ""},
+		{"incompressible type", http.MethodGet, gzipHeader, "image/png", 10 * minSize, ""},
+		{"HEAD", http.MethodHead, gzipHeader, "application/json", 10 * minSize, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.Repeat("x", tt.size)
+			w := serveCompressTest(newCompressTestHandler(minSize, tt.contentType, body), tt.method, tt.header)
+
+			if got := w.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q; want %q", got, tt.wantEncoding)
+			}
+
+			if tt.wantEncoding == "" {
+				if tt.method == http.MethodGet && w.Body.String() != body {
+					t.Errorf("body = %d bytes; want the %d bytes sent", w.Body.Len(), len(body))
+				}
+				if got := w.Header().Get("ETag"); got != `"v1"` {
+					t.Errorf("ETag = %s; want the identity ETag", got)
+				}
+				return
+			}
+
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decoded) != body {
+				t.Errorf("decompressed body = %d bytes; want the %d bytes sent", len(decoded), len(body))
+			}
+			if got := w.Header().Get("ETag"); got != `"v1-gzip"` {
+				t.Errorf("ETag = %s; want %s", got, `"v1-gzip"`)
+			}
+			if got := w.Header().Values("Vary"); !slices.Contains(got, "Accept-Encoding") {
+				t.Errorf("Vary = %v; want Accept-Encoding", got)
+			}
+		})
+	}
+}
+
+func TestCompressNotModified(t *testing.T) {
+	h := newCompressTestHandler(10, "application/json", strings.Repeat("x", 100))
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		ifNoneMatch    string
+		wantStatus     int
+		wantETag       string
+	}{
+		{"compressed tag, compressed request", "gzip", `"v1-gzip"`, http.StatusNotModified, `"v1-gzip"`},
+		{"identity tag, identity request", "", `"v1"`, http.StatusNotModified, `"v1"`},
+		{"identity tag, compressed request", "gzip", `"v1"`, http.StatusNotModified, `"v1"`},
+		{"compressed tag, identity request", "", `"v1-gzip"`, http.StatusOK, `"v1"`},
+		{"other encoding's tag", "gzip", `"v1-deflate"`, http.StatusNotModified, `"v1"`},
+		{"stale tag", "gzip", `"v0-gzip"`, http.StatusOK, `"v1-gzip"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{"If-None-Match": {tt.ifNoneMatch}}
+			if tt.acceptEncoding != "" {
+				header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := serveCompressTest(h, http.MethodGet, header)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d; want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("ETag = %s; want %s", got, tt.wantETag)
+			}
+			if w.Code == http.StatusNotModified {
+				if w.Body.Len() != 0 {
+					t.Errorf("304 carries a %d byte body", w.Body.Len())
+				}
+				if got := w.Header().Values("Vary"); !slices.Contains(got, "Accept-Encoding") {
+					t.Errorf("Vary = %v; want Accept-Encoding", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/yanglyu520/movies-golang-web-api/internal/moviesio"
+)
+
+// responseEncoder renders a response body in one media type.
+type responseEncoder struct {
+	// name is the value of the format query parameter that selects this encoder.
+	name string
+	// mediaTypes lists the media types served by this encoder, the first one being used as the
+	// Content-Type of the response.
+	mediaTypes []string
+	encode     func(w io.Writer, data any, pretty bool) error
+}
+
+// responseEncoders is the registry of supported response formats. The first entry is the default
+// used when the client accepts anything.
+var responseEncoders = []*responseEncoder{
+	{name: "json", mediaTypes: []string{"application/json"}, encode: encodeJSON},
+	{name: "ndjson", mediaTypes: []string{"application/x-ndjson", "application/ndjson"}, encode: encodeNDJSON},
+	{name: "csv", mediaTypes: []string{"text/csv"}, encode: encodeCSV},
+	{name: "xml", mediaTypes: []string{"application/xml", "text/xml"}, encode: encodeXML},
+	{name: "msgpack", mediaTypes: []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}, encode: encodeMsgpack},
+}
+
+// negotiateEncoder picks one of the candidate encoders from the format query parameter, falling
+// back to the Accept header. The first candidate is used when the client accepts anything. It
+// reports false when none of the acceptable formats is among the candidates.
+func negotiateEncoder(r *http.Request, candidates []*responseEncoder) (*responseEncoder, bool) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		for _, enc := range candidates {
+			if enc.name == format {
+				return enc, true
+			}
+		}
+		return nil, false
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return candidates[0], true
+	}
+
+	for _, mediaRange := range parseAccept(accept) {
+		for _, enc := range candidates {
+			for _, mediaType := range enc.mediaTypes {
+				if matchMediaRange(mediaRange, mediaType) {
+					return enc, true
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// findEncoder returns the registered encoder with the given name.
+func findEncoder(name string) *responseEncoder {
+	for _, enc := range responseEncoders {
+		if enc.name == name {
+			return enc
+		}
+	}
+	panic("unknown response encoder: " + name)
+}
+
+// parseAccept returns the acceptable media ranges of an Accept header, most preferred first.
+// Ranges with a quality of zero are dropped.
+func parseAccept(accept string) []string {
+	type mediaRange struct {
+		value       string
+		quality     float64
+		specificity int
+	}
+
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+
+		specificity := 2
+		switch {
+		case mediaType == "*/*":
+			specificity = 0
+		case strings.HasSuffix(mediaType, "/*"):
+			specificity = 1
+		}
+
+		ranges = append(ranges, mediaRange{mediaType, quality, specificity})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].quality != ranges[j].quality {
+			return ranges[i].quality > ranges[j].quality
+		}
+		return ranges[i].specificity > ranges[j].specificity
+	})
+
+	values := make([]string, len(ranges))
+	for i := range ranges {
+		values[i] = ranges[i].value
+	}
+	return values
+}
+
+func matchMediaRange(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(mediaRange, "*")
+	return ok && strings.HasPrefix(mediaType, prefix)
+}
+
+// writeResponse encodes data in the format negotiated with the client and writes it with the
+// given status. A 406 Not Acceptable response is sent instead when no supported format is
+// acceptable.
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data any, headers http.Header) error {
+	enc, ok := negotiateEncoder(r, responseEncoders)
+	if !ok {
+		app.notAcceptableResponse(w, r)
+		return nil
+	}
+	return app.writeEncoded(w, r, enc, status, data, headers)
+}
+
+// writeCacheableResponse works like writeResponse, and also tags the response with an ETag
+// hashed from its body, answering 304 Not Modified when the client already holds that body.
+func (app *application) writeCacheableResponse(w http.ResponseWriter, r *http.Request, status int, data any, headers http.Header) error {
+	return app.writeTaggedResponse(w, r, status, data, headers, bodyETag)
+}
+
+// writeTaggedResponse works like writeCacheableResponse, with the ETag computed from the body by
+// tag.
+func (app *application) writeTaggedResponse(w http.ResponseWriter, r *http.Request, status int, data any, headers http.Header, tag func(body []byte) string) error {
+	enc, ok := negotiateEncoder(r, responseEncoders)
+	if !ok {
+		app.notAcceptableResponse(w, r)
+		return nil
+	}
+
+	body, err := encodeBody(r, enc, data)
+	if err != nil {
+		return err
+	}
+
+	etag := tag(body)
+	if app.notModified(w, r, etag) {
+		return nil
+	}
+
+	headers = headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	headers.Set("ETag", etag)
+
+	return writeBody(w, enc, status, body, headers)
+}
+
+func (app *application) writeEncoded(w http.ResponseWriter, r *http.Request, enc *responseEncoder, status int, data any, headers http.Header) error {
+	body, err := encodeBody(r, enc, data)
+	if err != nil {
+		return err
+	}
+	return writeBody(w, enc, status, body, headers)
+}
+
+// encodeBody renders data with enc, pretty printed unless the request asks for pretty=false.
+func encodeBody(r *http.Request, enc *responseEncoder, data any) ([]byte, error) {
+	pretty := true
+	if s := r.URL.Query().Get("pretty"); s != "" {
+		pretty, _ = strconv.ParseBool(s)
+	}
+
+	var buf bytes.Buffer
+	err := enc.encode(&buf, data, pretty)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeBody(w http.ResponseWriter, enc *responseEncoder, status int, body []byte, headers http.Header) error {
+	for k, v := range headers {
+		w.Header()[k] = v
+	}
+
+	contentType := enc.mediaTypes[0]
+	if strings.HasPrefix(contentType, "text/") {
+		contentType += "; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+
+	_, err := w.Write(body)
+	return err
+}
+
+func encodeJSON(w io.Writer, data any, pretty bool) error {
+	var (
+		js  []byte
+		err error
+	)
+	if pretty {
+		js, err = json.MarshalIndent(data, "", "\t")
+	} else {
+		js, err = json.Marshal(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	js = append(js, '\n')
+	_, err = w.Write(js)
+	return err
+}
+
+// encodeNDJSON writes every record of the response on its own line.
+func encodeNDJSON(w io.Writer, data any, _ bool) error {
+	value, err := toGeneric(data)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records(value) {
+		js, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		js = append(js, '\n')
+		if _, err = w.Write(js); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeCSV writes the records of the response as CSV with a header row holding the union of
+// their fields, in the order they first appear.
+func encodeCSV(w io.Writer, data any, _ bool) error {
+	value, err := toGeneric(data)
+	if err != nil {
+		return err
+	}
+
+	rows := records(value)
+
+	var header []string
+	seen := map[string]bool{}
+	for _, row := range rows {
+		obj, ok := row.(*orderedObject)
+		if !ok {
+			obj = &orderedObject{keys: []string{"value"}, values: map[string]any{"value": row}}
+		}
+		for _, key := range obj.keys {
+			if !seen[key] {
+				seen[key] = true
+				header = append(header, key)
+			}
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	if err = cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		obj, ok := row.(*orderedObject)
+		if !ok {
+			obj = &orderedObject{keys: []string{"value"}, values: map[string]any{"value": row}}
+		}
+
+		record := make([]string, len(header))
+		for i, key := range header {
+			record[i], err = csvCell(obj.values[key])
+			if err != nil {
+				return err
+			}
+		}
+		if err = cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvCell(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []any:
+		items := make([]string, len(v))
+		for i := range v {
+			item, err := csvCell(v[i])
+			if err != nil {
+				return "", err
+			}
+			items[i] = item
+		}
+		return strings.Join(items, moviesio.ListSeparator), nil
+	default:
+		js, err := json.Marshal(v)
+		return string(js), err
+	}
+}
+
+var xmlNameRX = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// encodeXML writes the response under a <response> root element. Object fields become child
+// elements and array items are written as repeated <item> elements.
+func encodeXML(w io.Writer, data any, pretty bool) error {
+	value, err := toGeneric(data)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	if pretty {
+		enc.Indent("", "\t")
+	}
+	if err = encodeXMLElement(enc, "response", value); err != nil {
+		return err
+	}
+	if err = enc.Flush(); err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+func encodeXMLElement(enc *xml.Encoder, name string, value any) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	// Keys that are not valid XML names, such as facet values, are kept in an attribute.
+	if !xmlNameRX.MatchString(name) {
+		start = xml.StartElement{
+			Name: xml.Name{Local: "entry"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}},
+		}
+	}
+
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case *orderedObject:
+		for _, key := range v.keys {
+			if err := encodeXMLElement(enc, key, v.values[key]); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if err := encodeXMLElement(enc, "item", item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		text, err := csvCell(v)
+		if err != nil {
+			return err
+		}
+		if err = enc.EncodeToken(xml.CharData(text)); err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+func encodeMsgpack(w io.Writer, data any, _ bool) error {
+	value, err := toGeneric(data)
+	if err != nil {
+		return err
+	}
+	return encodeMsgpackValue(msgpack.NewEncoder(w), value)
+}
+
+func encodeMsgpackValue(enc *msgpack.Encoder, value any) error {
+	switch v := value.(type) {
+	case *orderedObject:
+		if err := enc.EncodeMapLen(len(v.keys)); err != nil {
+			return err
+		}
+		for _, key := range v.keys {
+			if err := enc.EncodeString(key); err != nil {
+				return err
+			}
+			if err := encodeMsgpackValue(enc, v.values[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []any:
+		if err := enc.EncodeArrayLen(len(v)); err != nil {
+			return err
+		}
+		for _, item := range v {
+			if err := encodeMsgpackValue(enc, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return enc.EncodeInt(i)
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		return enc.EncodeFloat64(f)
+	default:
+		return enc.Encode(v)
+	}
+}
+
+// orderedObject is a decoded JSON object that remembers the order of its keys, so that every
+// format renders fields in the same order as the JSON output.
+type orderedObject struct {
+	keys   []string
+	values map[string]any
+}
+
+func (o *orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// toGeneric converts data to its JSON data model, so that the non-JSON encoders honour the same
+// json tags and custom marshalers as the JSON output. Objects are decoded as *orderedObject,
+// arrays as []any and numbers as json.Number.
+func toGeneric(data any) (any, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	return decodeGeneric(dec)
+}
+
+func decodeGeneric(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		obj := &orderedObject{values: map[string]any{}}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := keyTok.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected object key %v", keyTok)
+			}
+			value, err := decodeGeneric(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj.keys = append(obj.keys, key)
+			obj.values[key] = value
+		}
+		if _, err = dec.Token(); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	case json.Delim('['):
+		arr := []any{}
+		for dec.More() {
+			value, err := decodeGeneric(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		if _, err = dec.Token(); err != nil {
+			return nil, err
+		}
+		return arr, nil
+	case json.Delim('}'), json.Delim(']'):
+		return nil, errors.New("unexpected end of JSON value")
+	default:
+		return tok, nil
+	}
+}
+
+// records returns the rows of a response for the line and table based formats. The rows of an
+// envelope with a single array field, such as {"movies": [...]}, are the items of that array, and
+// an envelope wrapping a single object, such as {"movie": {...}}, has that object as its only row.
+func records(value any) []any {
+	switch v := value.(type) {
+	case []any:
+		return v
+	case *orderedObject:
+		var arrays []string
+		for _, key := range v.keys {
+			if _, ok := v.values[key].([]any); ok {
+				arrays = append(arrays, key)
+			}
+		}
+		if len(arrays) == 1 {
+			return v.values[arrays[0]].([]any)
+		}
+
+		if len(v.keys) == 1 {
+			if inner, ok := v.values[v.keys[0]].(*orderedObject); ok {
+				return []any{inner}
+			}
+		}
+		return []any{v}
+	default:
+		return []any{v}
+	}
+}
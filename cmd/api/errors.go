@@ -8,6 +8,16 @@ const (
 	errServerMessage     = "the server encountered a problem and could not process your request"
 	errNotFoundMessage   = "the requested resource could not be found"
 	errMessageNotAllowed = "method is not supported for this resource"
+
+	errEditConflictMessage     = "unable to update the record due to an edit conflict, please try again"
+	errNotAcceptableMessage    = "none of the requested response formats is supported"
+	errUnsupportedMediaMessage = "the request body is not in a supported format"
+
+	errPreconditionFailedMessage   = "the resource has been modified since the ETag in If-Match was issued"
+	errPreconditionRequiredMessage = "this request must carry an If-Match header or the expected version in its body"
+
+	errIdempotencyMismatchMessage   = "the Idempotency-Key has already been used for a different request"
+	errIdempotencyInProgressMessage = "a request with the same Idempotency-Key is still being processed"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -19,7 +29,13 @@ type envelop map[string]any
 func (app *application) genericErrorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
 	env := envelop{"error": message}
 
-	err := app.writeJSON(w, status, env, nil)
+	// Errors are sent in the negotiated format when there is one, and as JSON otherwise.
+	enc, ok := negotiateEncoder(r, responseEncoders)
+	if !ok {
+		enc = responseEncoders[0]
+	}
+
+	err := app.writeEncoded(w, r, enc, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+)
+
+// movieETag is the strong entity tag of a movie revision, which If-Match preconditions of writes
+// are checked against.
+func movieETag(movie *data.Movie) string {
+	return fmt.Sprintf(`"%d-%d"`, movie.ID, movie.Version)
+}
+
+// movieBodyETag is the strong entity tag of one representation of a movie revision. It extends
+// movieETag with a hash of the encoded body, since the fields, format and pretty printing asked
+// for change the body, and still matches movieETag in If-Match.
+func movieBodyETag(movie *data.Movie, body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`"%d-%d-%s"`, movie.ID, movie.Version, hex.EncodeToString(sum[:movieBodyHashLen/2]))
+}
+
+// movieBodyHashLen is the length of the hex encoded body hash in a movieBodyETag.
+const movieBodyHashLen = 16
+
+// bodyETag is a strong entity tag hashed from an encoded response body.
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether etag is listed in an If-Match or If-None-Match header value. With
+// weak comparison, as used by If-None-Match, the W/ prefix is ignored; with strong comparison,
+// as used by If-Match, weak tags never match.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified answers a GET carrying an If-None-Match header that matches etag with 304 Not
+// Modified, and reports whether it did.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch == "" || !etagMatches(ifNoneMatch, etag, true) {
+		return false
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// checkIfMatch enforces the If-Match precondition of a write to movie. A request carrying the
+// expected version in its body has already been checked and passes bodyVersion as true. It
+// responds with 428 when no precondition was given and 412 when it does not match, and reports
+// whether the write may go ahead.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, movie *data.Movie, bodyVersion bool) bool {
+	if bodyVersion {
+		return true
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	switch {
+	case ifMatch == "":
+		app.preconditionRequiredResponse(w, r)
+		return false
+	case !movieETagMatches(ifMatch, movie):
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+	return true
+}
+
+// movieETagMatches reports whether an If-Match header value lists the current revision of movie,
+// by its movieETag or by the movieBodyETag of any of its representations, either possibly as
+// changed by encodedETag.
+func movieETagMatches(header string, movie *data.Movie) bool {
+	prefix := strings.TrimSuffix(movieETag(movie), `"`)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		rest, ok := strings.CutPrefix(candidate, prefix)
+		if !ok {
+			continue
+		}
+		if suffix, ok := strings.CutSuffix(rest, `"`); ok && movieETagSuffix(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// movieETagSuffix reports whether suffix is what movieBodyETag and encodedETag may add to a
+// movieETag: an optional "-" and body hash, followed by an optional "-" and content encoding.
+func movieETagSuffix(suffix string) bool {
+	for _, encoding := range contentEncodings {
+		if rest, ok := strings.CutSuffix(suffix, "-"+encoding); ok {
+			suffix = rest
+			break
+		}
+	}
+	if suffix == "" {
+		return true
+	}
+
+	hash, ok := strings.CutPrefix(suffix, "-")
+	if !ok || len(hash) != movieBodyHashLen {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil && strings.ToLower(hash) == hash
+}
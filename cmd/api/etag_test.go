@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+)
+
+func newTestApplication() *application {
+	return &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+}
+
+func TestMovieETagMatches(t *testing.T) {
+	movie := &data.Movie{ID: 12, Version: 3}
+	bodyTag := movieBodyETag(movie, []byte(`{"movie":{}}`))
+
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"revision tag", `"12-3"`, true},
+		{"body tag", bodyTag, true},
+		{"gzip revision tag", `"12-3-gzip"`, true},
+		{"deflate body tag", encodedETag(bodyTag, "deflate"), true},
+		{"wildcard", `*`, true},
+		{"listed among others", `"1-1", "12-3"`, true},
+		{"older version", `"12-2"`, false},
+		{"other movie sharing the prefix", `"12-30"`, false},
+		{"weak tag", `W/"12-3"`, false},
+		{"unquoted", `12-3`, false},
+		{"arbitrary suffix", `"12-3-anything"`, false},
+		{"short hash", `"12-3-abcdef"`, false},
+		{"upper case hash", `"12-3-0123456789ABCDEF"`, false},
+		{"unknown encoding", `"12-3-br"`, false},
+		{"encoding before hash", `"12-3-gzip-0123456789abcdef"`, false},
+		{"empty", ``, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := movieETagMatches(tt.header, movie); got != tt.want {
+				t.Errorf("movieETagMatches(%q) = %t; want %t", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	app := newTestApplication()
+	movie := &data.Movie{ID: 12, Version: 3}
+
+	tests := []struct {
+		name        string
+		ifMatch     string
+		bodyVersion bool
+		wantOK      bool
+		wantStatus  int
+	}{
+		{"version in body", "", true, true, http.StatusOK},
+		{"version in body wins over a stale If-Match", `"12-2"`, true, true, http.StatusOK},
+		{"no precondition", "", false, false, http.StatusPreconditionRequired},
+		{"matching If-Match", `"12-3"`, false, true, http.StatusOK},
+		{"stale If-Match", `"12-2"`, false, false, http.StatusPreconditionFailed},
+		{"wildcard", `*`, false, true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/v1/movies/12", nil)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			w := httptest.NewRecorder()
+
+			ok := app.checkIfMatch(w, r, movie, tt.bodyVersion)
+			if ok != tt.wantOK {
+				t.Errorf("checkIfMatch = %t; want %t", ok, tt.wantOK)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d; want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	"github.com/yanglyu520/movies-golang-web-api/internal/validator"
+)
+
+//go:embed schema.graphql
+var graphqlSchema string
+
+//go:embed dist/graphiql.html
+var graphiqlIndex []byte
+
+const (
+	// graphqlMaxDepth bounds the nesting of a query. The movie schema is only three levels deep;
+	// the limit leaves room for the introspection query GraphiQL sends, whose type references
+	// nest about a dozen levels.
+	graphqlMaxDepth = 15
+	// graphqlMaxComplexity bounds the work a single request can ask for. See graphqlBudget for
+	// how it is counted.
+	graphqlMaxComplexity = 1000
+	// graphqlMaxQueryLength bounds the length of the query document, in bytes.
+	graphqlMaxQueryLength = 10_000
+	// graphqlMutationCost is the base cost of a mutation.
+	graphqlMutationCost = 10
+)
+
+// Error codes sent in the extensions of a GraphQL error, for clients to tell errors apart.
+const (
+	graphqlCodeValidation  = "VALIDATION_FAILED"
+	graphqlCodeNotFound    = "NOT_FOUND"
+	graphqlCodeConflict    = "EDIT_CONFLICT"
+	graphqlCodeComplexity  = "COMPLEXITY_LIMIT_EXCEEDED"
+	graphqlCodeServerError = "INTERNAL_SERVER_ERROR"
+)
+
+// graphqlError is an error returned by a resolver. Its code and validation errors are sent in the
+// extensions of the GraphQL error.
+type graphqlError struct {
+	message string
+	code    string
+	fields  map[string]string
+}
+
+func (e *graphqlError) Error() string {
+	return e.message
+}
+
+// Extensions implements the interface graphql-go uses to add extensions to an error.
+func (e *graphqlError) Extensions() map[string]any {
+	ext := map[string]any{"code": e.code}
+	if len(e.fields) > 0 {
+		ext["fields"] = e.fields
+	}
+	return ext
+}
+
+var (
+	errGraphQLNotFound = &graphqlError{message: errNotFoundMessage, code: graphqlCodeNotFound}
+	errGraphQLConflict = &graphqlError{message: errEditConflictMessage, code: graphqlCodeConflict}
+)
+
+func graphqlValidationError(errors map[string]string) error {
+	return &graphqlError{message: "the input failed validation", code: graphqlCodeValidation, fields: errors}
+}
+
+type graphqlContextKey int
+
+const (
+	graphqlModelsKey graphqlContextKey = iota
+	graphqlBudgetKey
+)
+
+// graphqlBudget is the complexity left to a request. Root fields are charged before they touch
+// the database: a field returning a single movie costs one point plus one per selected field,
+// and a movie list costs one point plus one per selected field of every movie of the page.
+// A mutation costs graphqlMutationCost. Requests running out of budget get an error for the
+// fields that could not be afforded.
+type graphqlBudget struct {
+	remaining atomic.Int64
+}
+
+func (b *graphqlBudget) charge(cost int) error {
+	if b.remaining.Add(-int64(cost)) < 0 {
+		return &graphqlError{
+			message: fmt.Sprintf("the query exceeds the maximum complexity of %d", graphqlMaxComplexity),
+			code:    graphqlCodeComplexity,
+		}
+	}
+	return nil
+}
+
+// graphqlResolver is the root resolver of the GraphQL schema. Resolvers take the models from the
+// request context, so that mutations are audited with the request's actor.
+type graphqlResolver struct {
+	app *application
+}
+
+func (res *graphqlResolver) models(ctx context.Context) data.Models {
+	return ctx.Value(graphqlModelsKey).(data.Models)
+}
+
+func (res *graphqlResolver) charge(ctx context.Context, cost int) error {
+	return ctx.Value(graphqlBudgetKey).(*graphqlBudget).charge(cost)
+}
+
+// serverError logs err and returns the error sent to the client in its place.
+func (res *graphqlResolver) serverError(err error) error {
+	res.app.logger.Error(err.Error())
+	return &graphqlError{message: errServerMessage, code: graphqlCodeServerError}
+}
+
+// selectedMovieFields returns the Movie fields selected under prefix, which are also the names
+// of their columns.
+func selectedMovieFields(ctx context.Context, prefix string) []string {
+	var fields []string
+	for _, name := range graphql.SelectedFieldNames(ctx) {
+		field, ok := strings.CutPrefix(name, prefix)
+		if ok && !strings.Contains(field, ".") {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func parseMovieID(id graphql.ID) (int64, bool) {
+	n, err := strconv.ParseInt(string(id), 10, 64)
+	return n, err == nil && n > 0
+}
+
+func (res *graphqlResolver) Movie(ctx context.Context, args struct{ ID graphql.ID }) (*movieResolver, error) {
+	fields := selectedMovieFields(ctx, "")
+	if err := res.charge(ctx, 1+len(fields)); err != nil {
+		return nil, err
+	}
+
+	id, ok := parseMovieID(args.ID)
+	if !ok {
+		return nil, nil
+	}
+
+	movie, err := res.models(ctx).Movies.Get(id, fields...)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, nil
+		default:
+			return nil, res.serverError(err)
+		}
+	}
+	return &movieResolver{movie}, nil
+}
+
+type moviesArgs struct {
+	Title      string
+	Genres     []string
+	Page       int32
+	PageSize   int32
+	Sort       string
+	Fuzzy      bool
+	Similarity float64
+}
+
+func (res *graphqlResolver) Movies(ctx context.Context, args moviesArgs) (*movieListResolver, error) {
+	filters := data.Filters{
+		Page:         int(args.Page),
+		PageSize:     int(args.PageSize),
+		Sort:         args.Sort,
+		SortSafelist: []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"},
+		Fuzzy:        args.Fuzzy,
+		Similarity:   args.Similarity,
+		Fields:       selectedMovieFields(ctx, "movies."),
+	}
+
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		return nil, graphqlValidationError(v.Errors)
+	}
+
+	if err := res.charge(ctx, 1+filters.PageSize*len(filters.Fields)); err != nil {
+		return nil, err
+	}
+	// Only the metadata was asked for; the ids are the cheapest column to read.
+	if len(filters.Fields) == 0 {
+		filters.Fields = []string{"id"}
+	}
+
+	movies, metadata, err := res.models(ctx).Movies.GetAll(ctx, args.Title, args.Genres, filters)
+	if err != nil {
+		return nil, res.serverError(err)
+	}
+	return &movieListResolver{movies: movies, metadata: metadata}, nil
+}
+
+type createMovieArgs struct {
+	Input struct {
+		Title   string
+		Year    int32
+		Runtime int32
+		Genres  []string
+	}
+}
+
+func (res *graphqlResolver) CreateMovie(ctx context.Context, args createMovieArgs) (*movieResolver, error) {
+	if err := res.charge(ctx, graphqlMutationCost); err != nil {
+		return nil, err
+	}
+
+	movie := &data.Movie{
+		Title:     args.Input.Title,
+		Year:      args.Input.Year,
+		Runtime:   args.Input.Runtime,
+		Genres:    args.Input.Genres,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return nil, graphqlValidationError(v.Errors)
+	}
+
+	if err := res.models(ctx).Movies.Insert(movie); err != nil {
+		return nil, res.serverError(err)
+	}
+	return &movieResolver{movie}, nil
+}
+
+type updateMovieArgs struct {
+	ID      graphql.ID
+	Version *int32
+	Input   struct {
+		Title   *string
+		Year    *int32
+		Runtime *int32
+		Genres  *[]string
+	}
+}
+
+func (res *graphqlResolver) UpdateMovie(ctx context.Context, args updateMovieArgs) (*movieResolver, error) {
+	if err := res.charge(ctx, graphqlMutationCost); err != nil {
+		return nil, err
+	}
+
+	id, ok := parseMovieID(args.ID)
+	if !ok {
+		return nil, errGraphQLNotFound
+	}
+
+	models := res.models(ctx)
+	movie, err := models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, errGraphQLNotFound
+		default:
+			return nil, res.serverError(err)
+		}
+	}
+
+	if args.Version != nil && *args.Version != movie.Version {
+		return nil, errGraphQLConflict
+	}
+
+	if args.Input.Title != nil {
+		movie.Title = *args.Input.Title
+	}
+	if args.Input.Year != nil {
+		movie.Year = *args.Input.Year
+	}
+	if args.Input.Runtime != nil {
+		movie.Runtime = *args.Input.Runtime
+	}
+	if args.Input.Genres != nil {
+		movie.Genres = *args.Input.Genres
+	}
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return nil, graphqlValidationError(v.Errors)
+	}
+
+	err = models.Movies.Update(movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return nil, errGraphQLConflict
+		default:
+			return nil, res.serverError(err)
+		}
+	}
+	return &movieResolver{movie}, nil
+}
+
+func (res *graphqlResolver) DeleteMovie(ctx context.Context, args struct {
+	ID      graphql.ID
+	Version *int32
+}) (graphql.ID, error) {
+	if err := res.charge(ctx, graphqlMutationCost); err != nil {
+		return "", err
+	}
+
+	id, ok := parseMovieID(args.ID)
+	if !ok {
+		return "", errGraphQLNotFound
+	}
+
+	movies := res.models(ctx).Movies
+	var err error
+	if args.Version != nil {
+		err = movies.DeleteVersion(id, *args.Version)
+	} else {
+		err = movies.Delete(id)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return "", errGraphQLNotFound
+		case errors.Is(err, data.ErrEditConflict):
+			return "", errGraphQLConflict
+		default:
+			return "", res.serverError(err)
+		}
+	}
+	return args.ID, nil
+}
+
+type movieResolver struct {
+	movie *data.Movie
+}
+
+func (r *movieResolver) ID() graphql.ID {
+	return graphql.ID(strconv.FormatInt(r.movie.ID, 10))
+}
+
+func (r *movieResolver) Title() string {
+	return r.movie.Title
+}
+
+func (r *movieResolver) Year() int32 {
+	return r.movie.Year
+}
+
+func (r *movieResolver) Runtime() int32 {
+	return r.movie.Runtime
+}
+
+func (r *movieResolver) Genres() []string {
+	if r.movie.Genres == nil {
+		return []string{}
+	}
+	return r.movie.Genres
+}
+
+func (r *movieResolver) Version() int32 {
+	return r.movie.Version
+}
+
+type movieListResolver struct {
+	movies   []*data.Movie
+	metadata data.Metadata
+}
+
+func (r *movieListResolver) Movies() []*movieResolver {
+	movies := make([]*movieResolver, len(r.movies))
+	for i, movie := range r.movies {
+		movies[i] = &movieResolver{movie}
+	}
+	return movies
+}
+
+func (r *movieListResolver) Metadata() *metadataResolver {
+	return &metadataResolver{r.metadata}
+}
+
+type metadataResolver struct {
+	metadata data.Metadata
+}
+
+func (r *metadataResolver) CurrentPage() int32 {
+	return int32(r.metadata.CurrentPage)
+}
+
+func (r *metadataResolver) PageSize() int32 {
+	return int32(r.metadata.PageSize)
+}
+
+func (r *metadataResolver) FirstPage() int32 {
+	return int32(r.metadata.FirstPage)
+}
+
+func (r *metadataResolver) LastPage() int32 {
+	return int32(r.metadata.LastPage)
+}
+
+func (r *metadataResolver) TotalRecords() int32 {
+	return int32(r.metadata.TotalRecords)
+}
+
+func (r *metadataResolver) Suggestions() []string {
+	if r.metadata.Suggestions == nil {
+		return []string{}
+	}
+	return r.metadata.Suggestions
+}
+
+// RouteGraphQL registers the GraphQL endpoint and the GraphiQL page served from the same path.
+func (app *application) RouteGraphQL(r chi.Router) {
+	schema := graphql.MustParseSchema(graphqlSchema, &graphqlResolver{app: app},
+		graphql.UseStringDescriptions(),
+		graphql.MaxDepth(graphqlMaxDepth),
+		graphql.MaxQueryLength(graphqlMaxQueryLength),
+	)
+	index := newPrecompressedAsset(graphiqlIndex, "text/html")
+
+	r.Post("/graphql", func(w http.ResponseWriter, r *http.Request) {
+		app.HandleGraphQL(w, r, schema)
+	})
+	r.Get("/graphql", func(w http.ResponseWriter, r *http.Request) {
+		app.HandleStaticFile(w, r, index)
+	})
+}
+
+// HandleGraphQL is the handler for the GraphQL endpoint
+//
+//	@Summary		GraphQL endpoint
+//	@Description	Runs a GraphQL query or mutation against the movie catalog. Query errors,
+//	@Description	including validation errors, are reported in the errors of the response with
+//	@Description	a code in their extensions. Queries are limited in depth and complexity; a
+//	@Description	GraphiQL page to explore the schema is served at GET /v1/graphql.
+//	@Tags			graphql
+//	@Accept			json
+//	@Produce		json
+//	@Param			X-Actor	header	string	false	"who is making the change, recorded in the audit log"
+//	@Success		200
+//	@Failure		400	{object}	error
+//	@Failure		422	{object}	error
+//	@Router			/v1/graphql [post]
+func (app *application) HandleGraphQL(w http.ResponseWriter, r *http.Request, schema *graphql.Schema) {
+	var input struct {
+		Query         string         `json:"query"`
+		OperationName string         `json:"operationName"`
+		Variables     map[string]any `json:"variables"`
+		// Extensions is sent by some clients and ignored.
+		Extensions map[string]any `json:"extensions"`
+	}
+
+	err := app.readJSONInput(w, r, &input)
+	if err != nil {
+		app.logError(r, err)
+		app.badRequestResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Query != "", "query", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	budget := &graphqlBudget{}
+	budget.remaining.Store(graphqlMaxComplexity)
+
+	ctx := context.WithValue(r.Context(), graphqlModelsKey, app.auditedModels(r))
+	ctx = context.WithValue(ctx, graphqlBudgetKey, budget)
+
+	response := schema.Exec(ctx, input.Query, input.OperationName, input.Variables)
+
+	err = app.writeJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	moviesv1 "github.com/yanglyu520/movies-golang-web-api/internal/pb/movies/v1"
+	"github.com/yanglyu520/movies-golang-web-api/internal/validator"
+)
+
+// serveGRPC serves the movies.v1.MovieService, gRPC health checking and server reflection on the
+// gRPC port. It only returns when the server fails.
+func (app *application) serveGRPC() error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", app.cfg.grpc.port))
+	if err != nil {
+		return err
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(app.grpcUnaryInterceptor),
+		grpc.ChainStreamInterceptor(app.grpcStreamInterceptor),
+	}
+	// With TLS enabled, gRPC is served with the same, reloading, certificate as the REST API.
+	if app.tls != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(app.tls.config())))
+	}
+
+	server := grpc.NewServer(opts...)
+
+	moviesv1.RegisterMovieServiceServer(server, &movieService{app: app})
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus(moviesv1.MovieService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	reflection.Register(server)
+
+	app.logger.Info("starting gRPC server", "port", app.cfg.grpc.port)
+
+	return server.Serve(lis)
+}
+
+// grpcUnaryInterceptor logs every call and turns a panicking handler into an Internal error, like
+// the Logger and Recoverer middleware of the REST API.
+func (app *application) grpcUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	start := time.Now()
+	defer func() {
+		err = app.recoverGRPC(recover(), err)
+		app.logger.Info("grpc call", "method", info.FullMethod, "code", status.Code(err).String(), "duration", time.Since(start))
+	}()
+
+	return handler(ctx, req)
+}
+
+func (app *application) grpcStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	start := time.Now()
+	defer func() {
+		err = app.recoverGRPC(recover(), err)
+		app.logger.Info("grpc stream", "method", info.FullMethod, "code", status.Code(err).String(), "duration", time.Since(start))
+	}()
+
+	return handler(srv, ss)
+}
+
+// recoverGRPC returns the error to report for a call that panicked with p, or err when it did
+// not panic.
+func (app *application) recoverGRPC(p any, err error) error {
+	if p == nil {
+		return err
+	}
+	app.logger.Error(fmt.Sprintf("panic: %v", p), "stack", string(debug.Stack()))
+	return status.Error(codes.Internal, errServerMessage)
+}
+
+// movieService implements movies.v1.MovieService on top of the same models as the REST API.
+type movieService struct {
+	moviesv1.UnimplementedMovieServiceServer
+	app *application
+}
+
+// models returns the models to make changes with, auditing them with the caller's x-actor and
+// x-request-id metadata.
+func (s *movieService) models(ctx context.Context) data.Models {
+	var auditor data.Auditor
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if actor := md.Get("x-actor"); len(actor) > 0 {
+		auditor.Actor = actor[0]
+		if len(auditor.Actor) > maxActorLength {
+			auditor.Actor = auditor.Actor[:maxActorLength]
+		}
+	}
+	if requestID := md.Get("x-request-id"); len(requestID) > 0 {
+		auditor.RequestID = requestID[0]
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		auditor.RemoteIP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(auditor.RemoteIP); err == nil {
+			auditor.RemoteIP = host
+		}
+	}
+
+	return s.app.models.WithAuditor(auditor)
+}
+
+// serverError logs err and returns the error sent to the client in its place.
+func (s *movieService) serverError(err error) error {
+	s.app.logger.Error(err.Error())
+	return status.Error(codes.Internal, errServerMessage)
+}
+
+var (
+	errGRPCNotFound = status.Error(codes.NotFound, errNotFoundMessage)
+	errGRPCConflict = status.Error(codes.Aborted, errEditConflictMessage)
+)
+
+// invalidArgument returns an InvalidArgument error carrying the validation errors as a
+// google.rpc.BadRequest detail.
+func invalidArgument(errors map[string]string) error {
+	fields := make([]string, 0, len(errors))
+	for field := range errors {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	badRequest := &errdetails.BadRequest{}
+	for _, field := range fields {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: errors[field],
+		})
+	}
+
+	st, err := status.New(codes.InvalidArgument, "the input failed validation").WithDetails(badRequest)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "the input failed validation")
+	}
+	return st.Err()
+}
+
+func movieToProto(movie *data.Movie) *moviesv1.Movie {
+	return &moviesv1.Movie{
+		Id:      movie.ID,
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+		Version: movie.Version,
+	}
+}
+
+func (s *movieService) Get(ctx context.Context, req *moviesv1.GetRequest) (*moviesv1.GetResponse, error) {
+	movie, err := s.app.models.Movies.Get(req.GetId())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, errGRPCNotFound
+		default:
+			return nil, s.serverError(err)
+		}
+	}
+	return &moviesv1.GetResponse{Movie: movieToProto(movie)}, nil
+}
+
+func (s *movieService) List(ctx context.Context, req *moviesv1.ListRequest) (*moviesv1.ListResponse, error) {
+	filters := data.Filters{
+		Page:         int(req.GetPage()),
+		PageSize:     int(req.GetPageSize()),
+		Sort:         req.GetSort(),
+		SortSafelist: []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"},
+		Fuzzy:        req.GetFuzzy(),
+		Similarity:   data.DefaultSimilarity,
+	}
+	if filters.Page == 0 {
+		filters.Page = 1
+	}
+	if filters.PageSize == 0 {
+		filters.PageSize = 20
+	}
+	if filters.Sort == "" {
+		filters.Sort = "id"
+	}
+	if req.Similarity != nil {
+		filters.Similarity = req.GetSimilarity()
+	}
+
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		return nil, invalidArgument(v.Errors)
+	}
+
+	movies, metadata, err := s.app.models.Movies.GetAll(ctx, req.GetTitle(), req.GetGenres(), filters)
+	if err != nil {
+		return nil, s.serverError(err)
+	}
+
+	resp := &moviesv1.ListResponse{
+		Movies: make([]*moviesv1.Movie, len(movies)),
+		Metadata: &moviesv1.Metadata{
+			CurrentPage:  int32(metadata.CurrentPage),
+			PageSize:     int32(metadata.PageSize),
+			FirstPage:    int32(metadata.FirstPage),
+			LastPage:     int32(metadata.LastPage),
+			TotalRecords: int32(metadata.TotalRecords),
+			Suggestions:  metadata.Suggestions,
+		},
+	}
+	for i, movie := range movies {
+		resp.Movies[i] = movieToProto(movie)
+	}
+	return resp, nil
+}
+
+func (s *movieService) Create(ctx context.Context, req *moviesv1.CreateRequest) (*moviesv1.CreateResponse, error) {
+	movie := &data.Movie{
+		Title:     req.GetTitle(),
+		Year:      req.GetYear(),
+		Runtime:   req.GetRuntime(),
+		Genres:    req.GetGenres(),
+		CreatedAt: time.Now().Unix(),
+	}
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return nil, invalidArgument(v.Errors)
+	}
+
+	if err := s.models(ctx).Movies.Insert(movie); err != nil {
+		return nil, s.serverError(err)
+	}
+	return &moviesv1.CreateResponse{Movie: movieToProto(movie)}, nil
+}
+
+// movieUpdatePaths are the fields an update mask can name.
+var movieUpdatePaths = []string{"title", "year", "runtime", "genres"}
+
+func (s *movieService) Update(ctx context.Context, req *moviesv1.UpdateRequest) (*moviesv1.UpdateResponse, error) {
+	input := req.GetMovie()
+	paths := req.GetUpdateMask().GetPaths()
+
+	v := validator.New()
+	v.Check(input != nil, "movie", "must be provided")
+	for _, path := range paths {
+		v.Check(validator.PermittedValue(path, movieUpdatePaths...), "update_mask", "invalid field path")
+	}
+	if !v.Valid() {
+		return nil, invalidArgument(v.Errors)
+	}
+	if len(paths) == 0 {
+		paths = movieUpdatePaths
+	}
+
+	models := s.models(ctx)
+	movie, err := models.Movies.Get(input.GetId())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, errGRPCNotFound
+		default:
+			return nil, s.serverError(err)
+		}
+	}
+
+	if input.GetVersion() != 0 && input.GetVersion() != movie.Version {
+		return nil, errGRPCConflict
+	}
+
+	for _, path := range paths {
+		switch path {
+		case "title":
+			movie.Title = input.GetTitle()
+		case "year":
+			movie.Year = input.GetYear()
+		case "runtime":
+			movie.Runtime = input.GetRuntime()
+		case "genres":
+			movie.Genres = input.GetGenres()
+		}
+	}
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return nil, invalidArgument(v.Errors)
+	}
+
+	err = models.Movies.Update(movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return nil, errGRPCConflict
+		default:
+			return nil, s.serverError(err)
+		}
+	}
+	return &moviesv1.UpdateResponse{Movie: movieToProto(movie)}, nil
+}
+
+func (s *movieService) Delete(ctx context.Context, req *moviesv1.DeleteRequest) (*moviesv1.DeleteResponse, error) {
+	movies := s.models(ctx).Movies
+
+	var err error
+	if req.GetVersion() != 0 {
+		err = movies.DeleteVersion(req.GetId(), req.GetVersion())
+	} else {
+		err = movies.Delete(req.GetId())
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, errGRPCNotFound
+		case errors.Is(err, data.ErrEditConflict):
+			return nil, errGRPCConflict
+		default:
+			return nil, s.serverError(err)
+		}
+	}
+	return &moviesv1.DeleteResponse{}, nil
+}
+
+// Watch streams the movie changes received by the event hub, like GET /v1/movies/events.
+func (s *movieService) Watch(req *moviesv1.WatchRequest, stream grpc.ServerStreamingServer[moviesv1.WatchResponse]) error {
+	var lastEventID string
+	if req.GetAfterSeq() > 0 {
+		lastEventID = strconv.FormatInt(req.GetAfterSeq(), 10)
+	}
+
+	sub, backlog := s.app.events.subscribe(lastEventID)
+	defer s.app.events.unsubscribe(sub)
+
+	send := func(ev *movieEvent) error {
+		if !ev.matches(req.GetGenres()) {
+			return nil
+		}
+		if ev.reset {
+			return stream.Send(&moviesv1.WatchResponse{Event: "reset"})
+		}
+		return stream.Send(&moviesv1.WatchResponse{
+			Seq:   ev.change.Seq,
+			Event: ev.change.Event(),
+			Movie: movieToProto(&ev.change.Movie),
+		})
+	}
+
+	for _, ev := range backlog {
+		if err := send(ev); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+
+		case ev, ok := <-sub.events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "the client is not keeping up with the stream, resume it with after_seq")
+			}
+			if err := send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -1,22 +1,44 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"os"
+	"runtime"
+	"time"
 
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
 	"github.com/yanglyu520/movies-golang-web-api/internal/utils/debugutils"
 )
 
 const version = "1.0.0"
 
 type systemInfo struct {
-	Env     string `json:"env"`
-	Version string `json:"version"`
+	Env       string `json:"env"`
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	// Uptime is the time since the server started, such as "26h3m12s".
+	Uptime string `json:"uptime"`
+}
+
+func (app *application) systemInfo() systemInfo {
+	return systemInfo{
+		Env:       app.cfg.env,
+		Version:   version,
+		GoVersion: runtime.Version(),
+		Uptime:    time.Since(app.startedAt).Round(time.Second).String(),
+	}
 }
 
 type envStruct struct {
 	Status     string     `json:"status"`
 	SystemInfo systemInfo `json:"system_info"`
 	BuiltSHA   string     `json:"built_sha"`
+	// Cache reports the read cache counters when the cache is enabled.
+	Cache *data.CacheStats `json:"cache,omitempty"`
+	// Checks are the readiness checks the status is derived from.
+	Checks map[string]healthCheck `json:"checks"`
 }
 
 type envWithEnvelop struct {
@@ -28,25 +50,42 @@ type envWithEnvelop struct {
 //	@Summary		Healthcheck
 //	@Description	Returns a healthcheck response including the Git SHA that was
 //	@Description	used to build the current binary. This does not use the HASH env
-//	@Description	variable but rather the binary debug symbols.
+//	@Description	variable but rather the binary debug symbols. When the read cache is enabled,
+//	@Description	its size and hit/miss counters are included. The status is that of the
+//	@Description	readiness checks of /v1/health/ready: a failing check makes the server
+//	@Description	unavailable and the response a 503. See /v1/health/live and /v1/health/ready
+//	@Description	for probes.
 //	@Tags			healthcheck
 //	@Produce		json
 //	@Success		200	{object}	envWithEnvelop
 //	@Failure		500	{object}	error
+//	@Failure		503	{object}	envWithEnvelop
 //	@Router			/v1/healthcheck [get]
 func (app *application) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), app.cfg.health.timeout)
+	defer cancel()
+
+	checks, ready := app.readinessChecks(ctx)
+
 	env := envStruct{
-		Status: "available",
-		SystemInfo: systemInfo{
-			Env:     app.cfg.env,
-			Version: version,
-		},
-		BuiltSHA: debugutils.CommitSHA(),
+		Status:     "available",
+		SystemInfo: app.systemInfo(),
+		BuiltSHA:   debugutils.CommitSHA(),
+		Checks:     checks,
+	}
+	status := http.StatusOK
+	if !ready {
+		env.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	if app.models.Movies.Cache != nil {
+		stats := app.models.Movies.Cache.Stats()
+		env.Cache = &stats
 	}
 
 	envWithEnvelop := envWithEnvelop{env}
 
-	err := app.writeJSON(w, http.StatusOK, envWithEnvelop, nil)
+	err := app.writeJSON(w, status, envWithEnvelop, http.Header{"Cache-Control": {"no-store"}})
 	if err != nil {
 		app.logError(r, err)
 	}
@@ -55,3 +94,187 @@ func (app *application) handleHealthCheck(w http.ResponseWriter, r *http.Request
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// Statuses of a readiness check.
+const (
+	checkPass = "pass"
+	checkFail = "fail"
+)
+
+// healthCheck is the outcome of one readiness check. Details depend on the check.
+type healthCheck struct {
+	Status  string         `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type livenessReport struct {
+	Status     string     `json:"status"`
+	SystemInfo systemInfo `json:"system_info"`
+}
+
+type readinessReport struct {
+	Status     string                 `json:"status"`
+	SystemInfo systemInfo             `json:"system_info"`
+	Checks     map[string]healthCheck `json:"checks"`
+}
+
+// HandleHealthLive is the handler for the liveness probe
+//
+//	@Summary		Liveness probe
+//	@Description	Reports that the process is up and serving requests. It checks no dependencies,
+//	@Description	so a failing database never gets the server restarted; use the readiness probe
+//	@Description	to take it out of rotation instead.
+//	@Tags			healthcheck
+//	@Produce		json
+//	@Success		200	{object}	livenessReport
+//	@Router			/v1/health/live [get]
+func (app *application) HandleHealthLive(w http.ResponseWriter, r *http.Request) {
+	report := livenessReport{Status: "alive", SystemInfo: app.systemInfo()}
+
+	err := app.writeJSON(w, http.StatusOK, report, http.Header{"Cache-Control": {"no-store"}})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// HandleHealthReady is the handler for the readiness probe
+//
+//	@Summary		Readiness probe
+//	@Description	Reports whether the server can serve traffic. It pings the database within
+//	@Description	-health-timeout, checks that the schema is at least at the latest migration the
+//	@Description	binary knows, that the connection pool is not saturated (-health-pool-threshold)
+//	@Description	and that the server is not in maintenance mode, which is on while the
+//	@Description	-maintenance-file exists. Any failing check turns the response into a 503, with
+//	@Description	the details of every check.
+//	@Tags			healthcheck
+//	@Produce		json
+//	@Success		200	{object}	readinessReport
+//	@Failure		503	{object}	readinessReport
+//	@Router			/v1/health/ready [get]
+func (app *application) HandleHealthReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), app.cfg.health.timeout)
+	defer cancel()
+
+	checks, ready := app.readinessChecks(ctx)
+	report := readinessReport{
+		Status:     "ready",
+		SystemInfo: app.systemInfo(),
+		Checks:     checks,
+	}
+
+	status := http.StatusOK
+	if !ready {
+		report.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	err := app.writeJSON(w, status, report, http.Header{"Cache-Control": {"no-store"}})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readinessChecks runs every readiness check and reports whether all of them passed.
+func (app *application) readinessChecks(ctx context.Context) (map[string]healthCheck, bool) {
+	checks := map[string]healthCheck{
+		"maintenance": app.checkMaintenance(),
+		// The pool is looked at before the other checks take connections from it.
+		"pool":       app.checkPool(),
+		"database":   app.checkDatabase(ctx),
+		"migrations": app.checkMigrations(ctx),
+	}
+
+	for _, check := range checks {
+		if check.Status != checkPass {
+			return checks, false
+		}
+	}
+	return checks, true
+}
+
+func (app *application) checkMaintenance() healthCheck {
+	if app.cfg.health.maintenanceFile == "" {
+		return healthCheck{Status: checkPass}
+	}
+
+	_, err := os.Stat(app.cfg.health.maintenanceFile)
+	switch {
+	case err == nil:
+		return healthCheck{Status: checkFail, Error: "the server is in maintenance mode"}
+	case !errors.Is(err, os.ErrNotExist):
+		return healthCheck{Status: checkFail, Error: err.Error()}
+	}
+	return healthCheck{Status: checkPass}
+}
+
+func (app *application) checkPool() healthCheck {
+	db := app.models.Movies.DB
+	if db == nil {
+		return healthCheck{Status: checkFail, Error: "no database connection pool"}
+	}
+
+	stats := db.Stats()
+	check := healthCheck{
+		Status: checkPass,
+		Details: map[string]any{
+			"in_use":     stats.InUse,
+			"idle":       stats.Idle,
+			"max_open":   stats.MaxOpenConnections,
+			"wait_count": stats.WaitCount,
+		},
+	}
+
+	// An unlimited pool cannot saturate.
+	if stats.MaxOpenConnections > 0 {
+		saturation := float64(stats.InUse) / float64(stats.MaxOpenConnections)
+		check.Details["saturation"] = saturation
+		if saturation >= app.cfg.health.poolThreshold {
+			check.Status = checkFail
+			check.Error = "the database connection pool is saturated"
+		}
+	}
+	return check
+}
+
+func (app *application) checkDatabase(ctx context.Context) healthCheck {
+	db := app.models.Movies.DB
+	if db == nil {
+		return healthCheck{Status: checkFail, Error: "no database connection pool"}
+	}
+
+	start := time.Now()
+	err := db.PingContext(ctx)
+	check := healthCheck{
+		Status:  checkPass,
+		Details: map[string]any{"latency_ms": time.Since(start).Milliseconds()},
+	}
+	if err != nil {
+		check.Status = checkFail
+		check.Error = err.Error()
+	}
+	return check
+}
+
+// checkMigrations fails while the schema is behind the migrations embedded in the binary. A
+// schema ahead of them passes, as during a rolling deployment of a newer version.
+func (app *application) checkMigrations(ctx context.Context) healthCheck {
+	if app.models.Movies.DB == nil {
+		return healthCheck{Status: checkFail, Error: "no database connection pool"}
+	}
+
+	applied, err := app.migrator.Version(ctx)
+	if err != nil {
+		return healthCheck{Status: checkFail, Error: err.Error()}
+	}
+
+	check := healthCheck{
+		Status:  checkPass,
+		Details: map[string]any{"version": applied, "latest": app.migrator.Latest()},
+	}
+	if applied < app.migrator.Latest() {
+		check.Status = checkFail
+		check.Error = "the database schema has pending migrations"
+	}
+	return check
+}
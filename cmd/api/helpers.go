@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -45,11 +46,55 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	}
 	return i
 }
+
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		v.AddError(key, "must be a number")
+		return defaultValue
+	}
+	return f
+}
+
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
+// readTime reads an RFC 3339 time from the query string, returning the zero time when it is
+// absent.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 time")
+		return time.Time{}
+	}
+	return t
+}
+
 func (app *application) readMovieIDParam(r *http.Request) (int64, error) {
-	movieIDString := chi.URLParam(r, "id")
+	return app.readIDParam(r)
+}
 
-	fmt.Println(movieIDString)
-	id, err := strconv.ParseInt(movieIDString, 10, 64)
+// readIDParam reads the positive integer id URL parameter of any resource.
+func (app *application) readIDParam(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil || id < 1 {
 		return 0, fmt.Errorf("invalid id parameter: %w", err)
 	}
@@ -120,3 +165,15 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data any, h
 
 	return nil
 }
+
+// clearDeadlines lifts the server read and write timeouts for a long running streaming request.
+func (app *application) clearDeadlines(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		app.logError(r, err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		app.logError(r, err)
+	}
+}
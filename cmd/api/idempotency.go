@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+)
+
+// idempotencyLease is how long a key stays locked by the request processing it. It outlasts the
+// request timeout, so that a key is only taken over once its request is certainly gone.
+const idempotencyLease = 2 * requestTimeout
+
+// idempotencyHeaders are the response headers replayed along with the status and body.
+var idempotencyHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// idempotencyRecorder passes a response through while keeping a copy of it.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// idempotent makes a POST or PATCH handler safe to retry. The first request carrying a given
+// Idempotency-Key header is processed and its response stored; a retry with the same key and the
+// same request replays that response, a retry while the first request is still running gets a
+// 409 (unless its lease ran out, see idempotencyLease), and reusing the key for a different request gets a 422.
+func (app *application) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > 255 {
+			app.failedValidationResponse(w, r, map[string]string{"Idempotency-Key": "must not be more than 255 bytes long"})
+			return
+		}
+
+		// The body is read up front to fingerprint the request, then handed on to the handler.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+		if err != nil {
+			app.logError(r, err)
+			app.badRequestResponse(w, r)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.New()
+		io.WriteString(sum, r.Method+" "+r.URL.Path+"\n")
+		sum.Write(body)
+		fingerprint := hex.EncodeToString(sum.Sum(nil))
+
+		record, err := app.models.Idempotency.Reserve(key, fingerprint, app.cfg.idempotency.ttl, idempotencyLease)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		switch {
+		case record != nil && record.Fingerprint != fingerprint:
+			app.genericErrorResponse(w, r, http.StatusUnprocessableEntity, errIdempotencyMismatchMessage)
+			return
+		case record != nil && record.Status == 0:
+			app.genericErrorResponse(w, r, http.StatusConflict, errIdempotencyInProgressMessage)
+			return
+		case record != nil:
+			for name, value := range record.Headers {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(record.Status)
+			_, err = w.Write(record.Body)
+			if err != nil {
+				app.logError(r, err)
+			}
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		defer func() {
+			// A server error leaves nothing worth replaying, so the key is freed for a retry.
+			if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+				if err := app.models.Idempotency.Release(key); err != nil {
+					app.logError(r, err)
+				}
+				return
+			}
+
+			headers := make(map[string]string)
+			for _, name := range idempotencyHeaders {
+				if value := rec.Header().Get(name); value != "" {
+					headers[name] = value
+				}
+			}
+
+			if err := app.models.Idempotency.Complete(key, rec.status, headers, rec.body.Bytes()); err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// purgeIdempotencyKeys periodically removes expired idempotency keys.
+func (app *application) purgeIdempotencyKeys(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := app.models.Idempotency.DeleteExpired()
+		if err != nil {
+			app.logger.Error(err.Error())
+			continue
+		}
+		if n > 0 {
+			app.logger.Info("purged expired idempotency keys", "count", n)
+		}
+	}
+}
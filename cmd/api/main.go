@@ -7,6 +7,7 @@ import (
 	"fmt"
 	_ "github.com/lib/pq"
 	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	"github.com/yanglyu520/movies-golang-web-api/internal/migrate"
 	"log/slog"
 	"net/http"
 	"os"
@@ -27,12 +28,60 @@ type config struct {
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  time.Duration
+		autoMigrate  bool
+	}
+	autocomplete struct {
+		cache bool
+	}
+	idempotency struct {
+		ttl time.Duration
+	}
+	compress struct {
+		minSize int
+	}
+	cache struct {
+		size int
+		ttl  time.Duration
+	}
+	webhooks struct {
+		interval     time.Duration
+		timeout      time.Duration
+		maxAttempts  int
+		allowPrivate bool
+	}
+	events struct {
+		buffer int
+	}
+	grpc struct {
+		port int
+	}
+	tls struct {
+		certFile       string
+		keyFile        string
+		clientCAFile   string
+		redirectPort   int
+		reloadInterval time.Duration
+	}
+	health struct {
+		timeout         time.Duration
+		poolThreshold   float64
+		maintenanceFile string
+	}
+	outbox struct {
+		sink      string
+		interval  time.Duration
+		retention time.Duration
 	}
 }
 type application struct {
-	cfg    config
-	logger *slog.Logger
-	models data.Models
+	cfg       config
+	logger    *slog.Logger
+	models    data.Models
+	events    *eventHub
+	migrator  *migrate.Migrator
+	startedAt time.Time
+	// tls is set when the server is serving TLS.
+	tls *tlsReloader
 }
 
 //	@title			Movies Web API
@@ -56,11 +105,49 @@ func main() {
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
+	flag.BoolVar(&cfg.db.autoMigrate, "auto-migrate", false, "Apply pending database migrations at startup")
+
+	flag.BoolVar(&cfg.autocomplete.cache, "autocomplete-cache", false, "Serve title autocomplete from an in-process trie")
+
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "How long Idempotency-Key responses are kept for replay")
+
+	flag.IntVar(&cfg.compress.minSize, "compress-min-size", 1024, "Smallest response body, in bytes, that is compressed")
+
+	flag.IntVar(&cfg.cache.size, "cache-size", 0, "Maximum number of movies and movie list pages kept in the read cache (0 disables it)")
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", time.Minute, "How long an entry of the read cache is served")
+
+	flag.DurationVar(&cfg.webhooks.interval, "webhook-interval", 5*time.Second, "How often due webhook deliveries are attempted")
+	flag.DurationVar(&cfg.webhooks.timeout, "webhook-timeout", 10*time.Second, "Timeout of a single webhook delivery attempt")
+	flag.IntVar(&cfg.webhooks.maxAttempts, "webhook-max-attempts", 10, "Attempts after which a webhook delivery is given up as dead")
+	flag.BoolVar(&cfg.webhooks.allowPrivate, "webhook-allow-private", false, "Allow webhooks to loopback, private and link-local addresses (local development only)")
+
+	flag.IntVar(&cfg.events.buffer, "events-buffer", 1000, "Number of recent movie events kept for clients resuming the event stream")
+
+	flag.StringVar(&cfg.tls.certFile, "tls-cert", "", "TLS certificate file (PEM); serves HTTPS and HTTP/2 when given with -tls-key")
+	flag.StringVar(&cfg.tls.keyFile, "tls-key", "", "TLS private key file (PEM)")
+	flag.StringVar(&cfg.tls.clientCAFile, "tls-client-ca", "", "CA bundle (PEM) client certificates must be signed by (enables mutual TLS)")
+	flag.IntVar(&cfg.tls.redirectPort, "http-redirect-port", 0, "Port redirecting plain HTTP to HTTPS when serving TLS (0 disables it)")
+	flag.DurationVar(&cfg.tls.reloadInterval, "tls-reload-interval", 10*time.Second, "How often the TLS files are checked for changes")
+
+	flag.IntVar(&cfg.grpc.port, "grpc-port", 4001, "gRPC server port (0 disables it)")
+
+	flag.DurationVar(&cfg.health.timeout, "health-timeout", 2*time.Second, "Timeout of the database checks of the readiness probe")
+	flag.Float64Var(&cfg.health.poolThreshold, "health-pool-threshold", 1, "Share of the database connections in use at which the readiness probe fails")
+	flag.StringVar(&cfg.health.maintenanceFile, "maintenance-file", "", "The readiness probe fails while this file exists, to take the server out of rotation")
+
+	flag.StringVar(&cfg.outbox.sink, "outbox-sink", "", "Where outbox events are published: stdout, file:<path> or an http(s) URL (empty disables the outbox)")
+	flag.DurationVar(&cfg.outbox.interval, "outbox-interval", time.Second, "How often the outbox is polled for events to publish")
+	flag.DurationVar(&cfg.outbox.retention, "outbox-retention", 7*24*time.Hour, "How long published outbox events are kept")
 
 	flag.Parse()
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
+	if cfg.events.buffer < 0 {
+		logger.Error("invalid -events-buffer: must not be negative", "value", cfg.events.buffer)
+		os.Exit(2)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.Error(err.Error())
@@ -69,10 +156,94 @@ func main() {
 
 	logger.Info("database connection pool established")
 
+	// api [flags] migrate ... runs a migration command instead of the server.
+	if flag.Arg(0) == "migrate" {
+		if err == nil {
+			err = runMigrate(db, logger, flag.Args()[1:])
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cfg.db.autoMigrate {
+		if err = autoMigrate(db, logger); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	models := data.NewModels(db)
+	if cfg.autocomplete.cache {
+		models.Movies.Titles = data.NewTitleIndex()
+		err = models.Movies.WarmTitleIndex()
+		if err != nil {
+			logger.Error(err.Error())
+		} else {
+			logger.Info("autocomplete title cache warmed")
+		}
+	}
+
+	if cfg.cache.size > 0 {
+		models.Movies.Cache = data.NewMovieCache(cfg.cache.size, cfg.cache.ttl)
+	}
+	// Without a relay nothing would publish or purge the outbox.
+	models.Movies.Outbox = cfg.outbox.sink != ""
+
+	migrator, err := newMigrator(db, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
 	app := &application{
-		cfg:    cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		cfg:       cfg,
+		logger:    logger,
+		models:    models,
+		events:    newEventHub(cfg.events.buffer),
+		migrator:  migrator,
+		startedAt: time.Now(),
+	}
+
+	if cfg.tls.certFile != "" || cfg.tls.keyFile != "" || cfg.tls.clientCAFile != "" {
+		app.tls, err = newTLSReloader(cfg.tls.certFile, cfg.tls.keyFile, cfg.tls.clientCAFile)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		go app.watchTLSFiles(app.tls, cfg.tls.reloadInterval)
+	}
+
+	go app.purgeIdempotencyKeys(time.Hour)
+	go app.deliverWebhooks(cfg.webhooks.interval)
+	if cfg.outbox.sink != "" {
+		sink, err := newOutboxSink(cfg.outbox.sink)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		go app.relayOutbox(sink, cfg.outbox.interval)
+	}
+	go func() {
+		errorLog := func(err error) {
+			app.logger.Error(err.Error())
+		}
+		handlers := data.MovieChangeHandlers{app.events}
+		if models.Movies.Titles != nil {
+			handlers = append(handlers, models.Movies.TitleIndexFeed(errorLog))
+		}
+		err := data.ListenMovieChanges(context.Background(), cfg.db.dsn, handlers, errorLog)
+		app.logger.Error("movie change listener stopped", "error", err)
+	}()
+
+	if cfg.grpc.port > 0 {
+		go func() {
+			err := app.serveGRPC()
+			app.logger.Error(err.Error())
+			os.Exit(1)
+		}()
 	}
 
 	server := &http.Server{
@@ -84,9 +255,28 @@ func main() {
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 	}
 
-	app.logger.Info("starting %s env server on port %d", app.cfg.env, app.cfg.port)
+	if app.tls == nil {
+		app.logger.Info("starting server", "env", app.cfg.env, "port", app.cfg.port)
+
+		err = server.ListenAndServe()
+		app.logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	if cfg.tls.redirectPort > 0 {
+		go func() {
+			err := app.serveHTTPSRedirect(cfg.tls.redirectPort)
+			app.logger.Error(err.Error())
+			os.Exit(1)
+		}()
+	}
+
+	// The certificate comes from the TLS config, which also enables HTTP/2.
+	server.TLSConfig = app.tls.config()
+
+	app.logger.Info("starting TLS server", "env", app.cfg.env, "port", app.cfg.port)
 
-	err = server.ListenAndServe()
+	err = server.ListenAndServeTLS("", "")
 	app.logger.Error(err.Error())
 	os.Exit(1)
 }
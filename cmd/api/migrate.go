@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/migrate"
+	"github.com/yanglyu520/movies-golang-web-api/migrations"
+)
+
+// migrateTimeout bounds a migrate command, including the wait for the migration lock.
+const migrateTimeout = 10 * time.Minute
+
+const migrateUsage = "usage: api [flags] migrate up | down [N] | status | goto VERSION"
+
+func newMigrator(db *sql.DB, logger *slog.Logger) (*migrate.Migrator, error) {
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+	migrator.Log = logger.Info
+	return migrator, nil
+}
+
+// autoMigrate applies the pending migrations at startup.
+func autoMigrate(db *sql.DB, logger *slog.Logger) error {
+	migrator, err := newMigrator(db, logger)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	n, err := migrator.Up(ctx)
+	if err != nil {
+		return err
+	}
+	logger.Info("database migrations applied", "applied", n, "version", migrator.Latest())
+	return nil
+}
+
+// runMigrate runs the migrate subcommand with its arguments:
+//
+//	up          applies every pending migration
+//	down [N]    rolls back the last N migrations, 1 by default
+//	status      lists the migrations and whether they are applied
+//	goto N      migrates up or down to version N
+func runMigrate(db *sql.DB, logger *slog.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	migrator, err := newMigrator(db, logger)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		n, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Info("migrated up", "applied", n)
+
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return errors.New("migrate down: N must be a positive integer")
+			}
+		}
+		n, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		logger.Info("migrated down", "rolled_back", n)
+
+	case args[0] == "goto" && len(args) == 2:
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return errors.New("migrate goto: VERSION must be a non-negative integer")
+		}
+		if err = migrator.Goto(ctx, version); err != nil {
+			return err
+		}
+		logger.Info("migrated", "version", version)
+
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		return printMigrationStatus(statuses)
+
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
+
+func printMigrationStatus(statuses []migrate.Status) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT\tSTATE")
+
+	for _, st := range statuses {
+		appliedAt, state := "-", "pending"
+		if !st.AppliedAt.IsZero() {
+			appliedAt, state = st.AppliedAt.Format(time.RFC3339), "applied"
+		}
+		switch {
+		case st.Unknown:
+			state = "applied, no migration file"
+		case st.Modified:
+			state = "applied, modified since"
+		}
+		fmt.Fprintf(tw, "%06d\t%s\t%s\t%s\n", st.Version, st.Name, appliedAt, state)
+	}
+
+	return tw.Flush()
+}
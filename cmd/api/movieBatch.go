@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	"github.com/yanglyu520/movies-golang-web-api/internal/validator"
+)
+
+// maxBatchOperations caps the number of operations in a single batch request.
+const maxBatchOperations = 100
+
+type batchOperation struct {
+	Op      string      `json:"op"`
+	ID      int64       `json:"id"`
+	Version int32       `json:"version"`
+	Movie   *movieInput `json:"movie"`
+}
+
+type batchResult struct {
+	Index  int         `json:"index"`
+	Op     string      `json:"op"`
+	Result string      `json:"result"`
+	Status int         `json:"status,omitempty"`
+	ID     int64       `json:"id,omitempty"`
+	Movie  *data.Movie `json:"movie,omitempty"`
+	Error  any         `json:"error,omitempty"`
+}
+
+// batchError is the failure of a single batch operation, with the status the equivalent single
+// movie request would have responded with.
+type batchError struct {
+	status  int
+	message any
+}
+
+func (e *batchError) Error() string {
+	return http.StatusText(e.status)
+}
+
+// errBatchAborted rolls back an all-or-nothing batch after one of its operations failed.
+var errBatchAborted = errors.New("batch aborted")
+
+// HandleMovieBatch is the handler for the transactional movie batch endpoint
+//
+//	@Summary		Create, update and delete movies in one transaction
+//	@Description	Runs a list of create, update (with the expected version) and delete operations
+//	@Description	in a single database transaction. By default the batch is all-or-nothing: the
+//	@Description	first failing operation rolls every change back. With best_effort=true each
+//	@Description	operation is applied on its own and failures are only reported.
+//	@Tags			movies
+//	@Accept			json
+//	@Param			best_effort	query	bool	false	"apply the operations that succeed"
+//	@Param			X-Actor		header	string	false	"actor recorded in the audit log"
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
+//	@Success		200	{array}		batchResult
+//	@Failure		400	{object}	error
+//	@Failure		404	{array}		batchResult	"an all-or-nothing batch was rolled back"
+//	@Failure		409	{array}		batchResult	"an all-or-nothing batch was rolled back"
+//	@Failure		422	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/v1/movies/batch [post]
+func (app *application) HandleMovieBatch(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Operations []batchOperation `json:"operations"`
+	}
+
+	v := validator.New()
+	bestEffort := app.readBool(r.URL.Query(), "best_effort", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.readJSONInput(w, r, &input)
+	if err != nil {
+		app.logError(r, err)
+		app.badRequestResponse(w, r)
+		return
+	}
+
+	v.Check(len(input.Operations) >= 1, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= maxBatchOperations, "operations", "must contain at most 100 operations")
+	for _, op := range input.Operations {
+		v.Check(validator.PermittedValue(op.Op, "create", "update", "delete"), "op", "must be create, update or delete")
+		v.Check(op.Op == "delete" || op.Movie != nil, "movie", "must be provided for create and update operations")
+		v.Check(op.Op == "create" || op.ID > 0, "id", "must be provided for update and delete operations")
+		v.Check(op.Op != "update" || op.Version > 0, "version", "must be provided for update operations")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results := make([]batchResult, len(input.Operations))
+	for i, op := range input.Operations {
+		results[i] = batchResult{Index: i, Op: op.Op, Result: "skipped"}
+	}
+
+	status := http.StatusOK
+	ctx := r.Context()
+
+	err = app.auditedModels(r).WithTx(ctx, func(tx data.Models) error {
+		for i, op := range input.Operations {
+			apply := func() error {
+				return app.applyBatchOperation(tx, op, &results[i])
+			}
+
+			if bestEffort {
+				err := tx.Savepoint(ctx, apply)
+				if err != nil && !recordBatchError(err, &results[i]) {
+					return err
+				}
+				continue
+			}
+
+			if err := apply(); err != nil {
+				if !recordBatchError(err, &results[i]) {
+					return err
+				}
+				status = results[i].Status
+				return errBatchAborted
+			}
+		}
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, errBatchAborted):
+		for i := range results {
+			if results[i].Status >= 200 && results[i].Status < 300 {
+				results[i].Result = "rolled_back"
+				results[i].Movie = nil
+			}
+		}
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, status, envelop{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// recordBatchError stores the failure of an operation in its result. It reports false for
+// unexpected errors, which abort the whole batch.
+func recordBatchError(err error, result *batchResult) bool {
+	var be *batchError
+	if !errors.As(err, &be) {
+		return false
+	}
+
+	result.Result = "failed"
+	result.Status = be.status
+	result.Error = be.message
+	return true
+}
+
+func (app *application) applyBatchOperation(tx data.Models, op batchOperation, result *batchResult) error {
+	switch op.Op {
+	case "create":
+		movie := &data.Movie{CreatedAt: time.Now().Unix()}
+		if err := applyBatchMovie(movie, op.Movie); err != nil {
+			return err
+		}
+
+		if err := tx.Movies.Insert(movie); err != nil {
+			return err
+		}
+
+		result.Result, result.Status, result.ID, result.Movie = "created", http.StatusCreated, movie.ID, movie
+		return nil
+
+	case "update":
+		movie, err := tx.Movies.Get(op.ID)
+		if err != nil {
+			return batchModelError(err)
+		}
+		if movie.Version != op.Version {
+			return batchModelError(data.ErrEditConflict)
+		}
+
+		if err = applyBatchMovie(movie, op.Movie); err != nil {
+			return err
+		}
+
+		if err = tx.Movies.Update(movie); err != nil {
+			return batchModelError(err)
+		}
+
+		result.Result, result.Status, result.ID, result.Movie = "updated", http.StatusOK, movie.ID, movie
+		return nil
+
+	default:
+		if err := tx.Movies.Delete(op.ID); err != nil {
+			return batchModelError(err)
+		}
+
+		result.Result, result.Status, result.ID = "deleted", http.StatusNoContent, op.ID
+		return nil
+	}
+}
+
+// applyBatchMovie copies the input onto movie and validates the result.
+func applyBatchMovie(movie *data.Movie, input *movieInput) error {
+	movie.Title = input.Title
+	movie.Year = input.Year
+	movie.Runtime = input.Runtime
+	movie.Genres = input.Genres
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return &batchError{status: http.StatusUnprocessableEntity, message: v.Errors}
+	}
+	return nil
+}
+
+// batchModelError maps the expected model errors to their response status.
+func batchModelError(err error) error {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return &batchError{status: http.StatusNotFound, message: errNotFoundMessage}
+	case errors.Is(err, data.ErrEditConflict):
+		return &batchError{status: http.StatusConflict, message: errEditConflictMessage}
+	default:
+		return err
+	}
+}
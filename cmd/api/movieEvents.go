@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+)
+
+const (
+	// eventHeartbeatInterval is how often an idle event stream is sent a comment, so that
+	// proxies and clients do not time the connection out.
+	eventHeartbeatInterval = 15 * time.Second
+	// eventSubscriberBuffer is the number of events queued for a client that is not keeping up.
+	// A client falling further behind is disconnected, and resumes from Last-Event-ID.
+	eventSubscriberBuffer = 64
+	// eventRetry is the reconnection delay suggested to clients, in milliseconds.
+	eventRetry = 3000
+)
+
+// movieEvent is a single event of the movie event stream. A reset event tells clients that
+// changes may have been missed, and that they should reload what they display.
+type movieEvent struct {
+	change *data.MovieChange
+	reset  bool
+}
+
+// matches reports whether the event concerns a movie with any of the genres. Every event matches
+// an empty genres list.
+func (ev *movieEvent) matches(genres []string) bool {
+	if ev.reset || len(genres) == 0 {
+		return true
+	}
+	for _, genre := range genres {
+		for _, movieGenre := range ev.change.Movie.Genres {
+			if genre == movieGenre {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ev *movieEvent) writeTo(w io.Writer) error {
+	if ev.reset {
+		_, err := io.WriteString(w, "event: reset\ndata: {}\n\n")
+		return err
+	}
+
+	js, err := json.Marshal(ev.change)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.change.Seq, ev.change.Event(), js)
+	return err
+}
+
+// eventHub fans the movie changes received from the database out to the connected clients. It
+// keeps the most recent events in a ring buffer, so that clients reconnecting with a
+// Last-Event-ID header get the events they missed.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[*eventSubscriber]struct{}
+	recent      []*movieEvent
+	next        int
+	full        bool
+}
+
+type eventSubscriber struct {
+	events chan *movieEvent
+}
+
+func newEventHub(size int) *eventHub {
+	return &eventHub{
+		subscribers: make(map[*eventSubscriber]struct{}),
+		recent:      make([]*movieEvent, size),
+	}
+}
+
+// Change implements data.MovieChangeHandler.
+func (h *eventHub) Change(change *data.MovieChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ev := &movieEvent{change: change}
+	if len(h.recent) > 0 {
+		h.recent[h.next] = ev
+		h.next = (h.next + 1) % len(h.recent)
+		h.full = h.full || h.next == 0
+	}
+	h.broadcast(ev)
+}
+
+// Reset implements data.MovieChangeHandler. The buffered events no longer tell the whole story,
+// so they are dropped.
+func (h *eventHub) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	clear(h.recent)
+	h.next, h.full = 0, false
+	h.broadcast(&movieEvent{reset: true})
+}
+
+// broadcast queues ev for every subscriber. h.mu must be held.
+func (h *eventHub) broadcast(ev *movieEvent) {
+	for sub := range h.subscribers {
+		select {
+		case sub.events <- ev:
+		default:
+			// The client is too slow; dropping it is better than silently skipping events.
+			delete(h.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// buffered returns the buffered events, oldest first. h.mu must be held.
+func (h *eventHub) buffered() []*movieEvent {
+	if !h.full {
+		return h.recent[:h.next]
+	}
+	return append(append([]*movieEvent{}, h.recent[h.next:]...), h.recent[:h.next]...)
+}
+
+// subscribe registers a new client. When lastEventID is given, the buffered events that followed
+// it are returned; if it is no longer buffered, a reset event is returned instead.
+func (h *eventHub) subscribe(lastEventID string) (*eventSubscriber, []*movieEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &eventSubscriber{events: make(chan *movieEvent, eventSubscriberBuffer)}
+	h.subscribers[sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil
+	}
+
+	seq, err := strconv.ParseInt(lastEventID, 10, 64)
+	if err == nil {
+		events := h.buffered()
+		for i, ev := range events {
+			if ev.change.Seq == seq {
+				return sub, append([]*movieEvent{}, events[i+1:]...)
+			}
+		}
+	}
+	return sub, []*movieEvent{{reset: true}}
+}
+
+func (h *eventHub) unsubscribe(sub *eventSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// HandleMovieEvents is the handler for the movie event stream
+//
+//	@Summary		Stream movie changes
+//	@Description	Streams movie.created, movie.updated and movie.deleted events as Server-Sent
+//	@Description	Events. A client reconnecting with a Last-Event-ID header first receives the
+//	@Description	events it missed, or a reset event when they are no longer available. With
+//	@Description	genres, only changes to movies with any of the genres are sent.
+//	@Tags			movies
+//	@Param			genres			query	string	false	"comma separated genres"
+//	@Param			Last-Event-ID	header	string	false	"id of the last event received"
+//	@Produce		text/event-stream
+//	@Success		200
+//	@Router			/v1/movies/events [get]
+func (app *application) HandleMovieEvents(w http.ResponseWriter, r *http.Request) {
+	genres := app.readCSV(r.URL.Query(), "genres", []string{})
+
+	// The stream stays open for as long as the client is connected.
+	app.clearDeadlines(w, r)
+	rc := http.NewResponseController(w)
+
+	sub, backlog := app.events.subscribe(r.Header.Get("Last-Event-ID"))
+	defer app.events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stops nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(events ...*movieEvent) error {
+		for _, ev := range events {
+			if !ev.matches(genres) {
+				continue
+			}
+			if err := ev.writeTo(w); err != nil {
+				return err
+			}
+		}
+		return rc.Flush()
+	}
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", eventRetry); err != nil {
+		return
+	}
+	if err := send(backlog...); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case ev, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			if err := send(ev); err != nil {
+				return
+			}
+
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
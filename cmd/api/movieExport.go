@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	"github.com/yanglyu520/movies-golang-web-api/internal/moviesio"
+	"github.com/yanglyu520/movies-golang-web-api/internal/validator"
+)
+
+// exportFlushInterval is the number of movies written between two flushes of the response.
+const exportFlushInterval = 1000
+
+// exportEncoders are the response encoders an export can be streamed in, the first one being the
+// default.
+var exportEncoders = []*responseEncoder{findEncoder("ndjson"), findEncoder("csv")}
+
+// exportRowWriter writes one movie of an export.
+type exportRowWriter interface {
+	write(movie *data.Movie) error
+	flush() error
+}
+
+type ndjsonExportWriter struct {
+	w http.ResponseWriter
+}
+
+func (ew ndjsonExportWriter) write(movie *data.Movie) error {
+	js, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
+	js = append(js, '\n')
+	_, err = ew.w.Write(js)
+	return err
+}
+
+func (ew ndjsonExportWriter) flush() error {
+	return nil
+}
+
+// csvExportColumns mirrors the JSON fields of a movie.
+var csvExportColumns = data.MovieFieldSafelist
+
+type csvExportWriter struct {
+	cw *csv.Writer
+}
+
+func (ew csvExportWriter) write(movie *data.Movie) error {
+	record := make([]string, len(csvExportColumns))
+	for i, column := range csvExportColumns {
+		switch column {
+		case "id":
+			record[i] = strconv.FormatInt(movie.ID, 10)
+		case "title":
+			record[i] = movie.Title
+		case "year":
+			record[i] = strconv.Itoa(int(movie.Year))
+		case "runtime":
+			record[i] = strconv.Itoa(int(movie.Runtime))
+		case "genres":
+			record[i] = strings.Join(movie.Genres, moviesio.ListSeparator)
+		case "version":
+			record[i] = strconv.Itoa(int(movie.Version))
+		}
+	}
+	return ew.cw.Write(record)
+}
+
+func (ew csvExportWriter) flush() error {
+	ew.cw.Flush()
+	return ew.cw.Error()
+}
+
+// HandleMovieExport is the handler for the full catalog export endpoint
+//
+//	@Summary		Export movies
+//	@Description	Streams every movie matching the title and genres filters, in id order, as
+//	@Description	NDJSON (the default) or CSV with genres separated by "|". The response is
+//	@Description	written as rows are read from the database, with no size or time limit.
+//	@Tags			movies
+//	@Param			title	query	string	false	"title search"
+//	@Param			genres	query	string	false	"comma separated genres"
+//	@Param			fuzzy	query	bool	false	"typo-tolerant title search"
+//	@Param			format	query	string	false	"ndjson or csv"
+//	@Produce		application/x-ndjson,text/csv
+//	@Success		200
+//	@Failure		406	{object}	error
+//	@Failure		422	{object}	error
+//	@Router			/v1/movies/export [get]
+func (app *application) HandleMovieExport(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title  string
+		Genres []string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Title = app.readString(qs, "title", "")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Filters.Fuzzy = app.readBool(qs, "fuzzy", false, v)
+	input.Filters.Similarity = app.readFloat(qs, "similarity", data.DefaultSimilarity, v)
+
+	// An export is not paginated and always in ID order, which the filters state so that the
+	// search parameters are validated as for the movie list.
+	input.Filters.Page = 1
+	input.Filters.PageSize = 100
+	input.Filters.Sort = "id"
+	input.Filters.SortSafelist = []string{"id"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	enc, ok := negotiateEncoder(r, exportEncoders)
+	if !ok {
+		app.notAcceptableResponse(w, r)
+		return
+	}
+
+	// The export runs for as long as the client keeps reading, so the server write timeout,
+	// which is meant for regular requests, is lifted.
+	app.clearDeadlines(w, r)
+	rc := http.NewResponseController(w)
+
+	var rows exportRowWriter
+	switch enc.name {
+	case "csv":
+		cw := csv.NewWriter(w)
+		rows = csvExportWriter{cw: cw}
+		defer func() {
+			if err := rows.flush(); err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+		w.WriteHeader(http.StatusOK)
+
+		if err := cw.Write(csvExportColumns); err != nil {
+			app.logError(r, err)
+			return
+		}
+	default:
+		rows = ndjsonExportWriter{w: w}
+
+		w.Header().Set("Content-Type", enc.mediaTypes[0])
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.ndjson"`)
+		w.WriteHeader(http.StatusOK)
+	}
+
+	written := 0
+	err := app.models.Movies.Export(r.Context(), input.Title, input.Genres, input.Filters, func(movie *data.Movie) error {
+		if err := rows.write(movie); err != nil {
+			return err
+		}
+
+		written++
+		if written%exportFlushInterval == 0 {
+			if err := rows.flush(); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// The status line has already been sent, so the only way left to signal the failure is
+		// to cut the response short.
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -1,8 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"math"
 	"net/http"
 	"time"
 
@@ -14,10 +15,19 @@ import (
 // HandleMovieList  is the handler for getting a list of movies endpoint
 //
 //	@Summary		Get a list of movies
-//	@Description	Get a list of movies
+//	@Description	Get a list of movies. With fuzzy=true the title is matched by trigram similarity,
+//	@Description	and an empty result carries the closest titles in metadata.suggestions.
 //	@Tags			movies
-//	@Produce		json
+//	@Param			title		query	string	false	"title search"
+//	@Param			genres		query	string	false	"comma separated genres"
+//	@Param			fuzzy		query	bool	false	"typo-tolerant title search"
+//	@Param			similarity	query	number	false	"minimum title similarity in fuzzy mode (0 to 1)"
+//	@Param			facets		query	string	false	"comma separated facets to count (genres, year, runtime)"
+//	@Param			fields		query	string	false	"comma separated movie fields to return"
+//	@Param			If-None-Match	header	string	false	"ETag of a cached response"
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
 //	@Success		200	{object}	data.MovieList
+//	@Success		304
 //	@Failure		400	{object}	error
 //	@Failure		404	{object}	error
 //	@Failure		500	{object}	error
@@ -27,6 +37,7 @@ func (app *application) HandleMovieList(w http.ResponseWriter, r *http.Request)
 	var input struct {
 		Title  string
 		Genres []string
+		Facets []string
 		data.Filters
 	}
 
@@ -41,17 +52,37 @@ func (app *application) HandleMovieList(w http.ResponseWriter, r *http.Request)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
 
+	input.Filters.Fuzzy = app.readBool(qs, "fuzzy", false, v)
+	input.Filters.Similarity = app.readFloat(qs, "similarity", data.DefaultSimilarity, v)
+
+	input.Filters.Fields = app.readCSV(qs, "fields", []string{})
+
+	input.Facets = app.readCSV(qs, "facets", []string{})
+	for _, facet := range input.Facets {
+		v.Check(validator.PermittedValue(facet, data.FacetSafelist...), "facets", "invalid facet value")
+	}
+
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.Title, input.Genres, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, data.MovieList{Movies: movies, Metadata: metadata}, nil)
+
+	var facets data.Facets
+	if len(input.Facets) > 0 {
+		facets, err = app.models.Movies.Facets(input.Title, input.Genres, input.Filters, input.Facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeCacheableResponse(w, r, http.StatusOK, data.MovieList{Movies: movies, Metadata: metadata, Facets: facets, Fields: input.Filters.Fields}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -67,10 +98,13 @@ type movieInput struct {
 // HandleMoviePost is the handler for creating a movie endpoint
 //
 //	@Summary		Create a new movie
-//	@Description	Create a new movie
+//	@Description	Create a new movie. A retry carrying the same Idempotency-Key header replays the
+//	@Description	original response instead of creating the movie again.
 //	@Tags			movies
 //	@Accept			json
-//	@Produce		json
+//	@Param			Idempotency-Key	header	string	false	"unique key identifying this request"
+//	@Param			X-Actor			header	string	false	"actor recorded in the audit log"
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
 //	@Success		200	{object}	data.Movie
 //	@Failure		400	{object}	error
 //	@Failure		404	{object}	error
@@ -87,8 +121,6 @@ func (app *application) HandleMoviePost(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	log.Println(input)
-
 	movie := &data.Movie{
 		Title:     input.Title,
 		Year:      input.Year,
@@ -103,19 +135,21 @@ func (app *application) HandleMoviePost(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.auditedModels(r).Movies.Insert(movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movieETag(movie))
 
 	movieWithEnvelop := map[string]any{
 		"movie": movie,
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, movieWithEnvelop, nil)
+	err = app.writeResponse(w, r, http.StatusCreated, movieWithEnvelop, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -124,11 +158,16 @@ func (app *application) HandleMoviePost(w http.ResponseWriter, r *http.Request)
 // HandleMovieGet  is the handler for getting a specific movie endpoint
 //
 //	@Summary		Get a specific movie
-//	@Description	Get  a specific movie
+//	@Description	Get  a specific movie. With as_of_version the movie is returned as it was at that
+//	@Description	version.
 //	@Tags			movies
-//	@Param			id	path	string	false	"movie ID"
-//	@Produce		json
+//	@Param			id				path	string	false	"movie ID"
+//	@Param			fields			query	string	false	"comma separated movie fields to return"
+//	@Param			as_of_version	query	int		false	"version to return"
+//	@Param			If-None-Match	header	string	false	"ETag of a cached response"
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
 //	@Success		200	{object}	data.Movie
+//	@Success		304
 //	@Failure		400	{object}	error
 //	@Failure		404	{object}	error
 //	@Failure		500	{object}	error
@@ -144,20 +183,45 @@ func (app *application) HandleMovieGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	movie := data.Movie{
-		ID:        id,
-		CreatedAt: time.Now().Unix(),
-		Title:     "something",
-		Runtime:   102,
-		Genres:    []string{"drama", "romance", "war"},
-		Version:   1,
+	v := validator.New()
+	qs := r.URL.Query()
+	fields := app.readCSV(qs, "fields", []string{})
+	asOfVersion := app.readInt(qs, "as_of_version", 0, v)
+	v.Check(!qs.Has("as_of_version") || asOfVersion > 0, "as_of_version", "must be greater than zero")
+	v.Check(asOfVersion <= math.MaxInt32, "as_of_version", "must be a valid version")
+	if data.ValidateFields(v, fields); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var movie *data.Movie
+	if asOfVersion > 0 {
+		var rev *data.MovieRevision
+		rev, err = app.models.Movies.GetRevision(id, int32(asOfVersion))
+		if err == nil {
+			movie = rev.Movie()
+		}
+	} else {
+		movie, err = app.models.Movies.Get(id, fields...)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
 	movieWithEnvelop := map[string]any{
-		"movie": movie,
+		"movie": movie.Project(fields),
 	}
 
-	err = app.writeJSON(w, http.StatusOK, movieWithEnvelop, nil)
+	tag := func(body []byte) string {
+		return movieBodyETag(movie, body)
+	}
+	err = app.writeTaggedResponse(w, r, http.StatusOK, movieWithEnvelop, nil, tag)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -166,13 +230,22 @@ func (app *application) HandleMovieGet(w http.ResponseWriter, r *http.Request) {
 // HandleMoviePut is the handler for the update a specific movie endpoint
 //
 //	@Summary		Update a specific movie
-//	@Description	Update a specific movie
+//	@Description	Update a specific movie. The request must either carry the ETag of the movie in an
+//	@Description	If-Match header (412 when it is stale) or the expected version in its body (409
+//	@Description	when it is stale); 428 is returned when it does neither.
 //	@Tags			movies
-//	@Param			id	path	string	false	"movie ID"
-//	@Produce		json
+//	@Param			id			path	string	false	"movie ID"
+//	@Param			If-Match	header	string	false	"ETag of the movie being replaced"
+//	@Param			X-Actor		header	string	false	"actor recorded in the audit log"
+//	@Accept			json
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
 //	@Success		200	{object}	data.Movie
 //	@Failure		400	{object}	error
 //	@Failure		404	{object}	error
+//	@Failure		409	{object}	error
+//	@Failure		412	{object}	error
+//	@Failure		422	{object}	error
+//	@Failure		428	{object}	error
 //	@Failure		500	{object}	error
 //	@Router			/v1/movies/{id} [put]
 func (app *application) HandleMoviePut(w http.ResponseWriter, r *http.Request) {
@@ -182,24 +255,188 @@ func (app *application) HandleMoviePut(w http.ResponseWriter, r *http.Request) {
 	id, err = app.readMovieIDParam(r)
 	if err != nil {
 		app.logError(r, err)
-		http.NotFound(w, r)
+		app.notFoundResponse(w, r)
 		return
 	}
 
-	movie := data.Movie{
-		ID:        id,
-		CreatedAt: time.Now().Unix(),
-		Title:     "something",
-		Runtime:   102,
-		Genres:    []string{"drama", "romance", "war"},
-		Version:   1,
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
+	var input struct {
+		movieInput
+		// Version optionally carries the expected version, as an alternative to If-Match.
+		Version *int32 `json:"version"`
+	}
+	err = app.readJSONInput(w, r, &input)
+	if err != nil {
+		app.logError(r, err)
+		app.badRequestResponse(w, r)
+		return
+	}
+
+	if input.Version != nil && *input.Version != movie.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+	if !app.checkIfMatch(w, r, movie, input.Version != nil) {
+		return
+	}
+
+	movie.Title = input.Title
+	movie.Year = input.Year
+	movie.Runtime = input.Runtime
+	movie.Genres = input.Genres
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.auditedModels(r).Movies.Update(movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict) && input.Version == nil:
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
 	movieWithEnvelop := map[string]any{
 		"movie": movie,
 	}
 
-	err = app.writeJSON(w, http.StatusOK, movieWithEnvelop, nil)
+	err = app.writeResponse(w, r, http.StatusOK, movieWithEnvelop, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// HandleMoviePatch is the handler for the partial update of a specific movie endpoint
+//
+//	@Summary		Partially update a specific movie
+//	@Description	Update only the fields present in the request body. The request must either carry
+//	@Description	the ETag of the movie in an If-Match header (412 when it is stale) or the expected
+//	@Description	version in its body (409 when it is stale); 428 is returned when it does neither.
+//	@Description	A retry carrying the same Idempotency-Key header replays the original response.
+//	@Tags			movies
+//	@Param			id				path	string	false	"movie ID"
+//	@Param			If-Match		header	string	false	"ETag of the movie being updated"
+//	@Param			Idempotency-Key	header	string	false	"unique key identifying this request"
+//	@Param			X-Actor			header	string	false	"actor recorded in the audit log"
+//	@Accept			json
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
+//	@Success		200	{object}	data.Movie
+//	@Failure		400	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		409	{object}	error
+//	@Failure		412	{object}	error
+//	@Failure		422	{object}	error
+//	@Failure		428	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/v1/movies/{id} [patch]
+func (app *application) HandleMoviePatch(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	var id int64
+	id, err = app.readMovieIDParam(r)
+	if err != nil {
+		app.logError(r, err)
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Pointers tell a field that was left out of the body apart from one set to its zero value.
+	var input struct {
+		Title   *string  `json:"title"`
+		Year    *int32   `json:"year"`
+		Runtime *int32   `json:"runtime"`
+		Genres  []string `json:"genres"`
+		// Version optionally carries the expected version, as an alternative to If-Match.
+		Version *int32 `json:"version"`
+	}
+
+	err = app.readJSONInput(w, r, &input)
+	if err != nil {
+		app.logError(r, err)
+		app.badRequestResponse(w, r)
+		return
+	}
+
+	if input.Version != nil && *input.Version != movie.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+	if !app.checkIfMatch(w, r, movie, input.Version != nil) {
+		return
+	}
+
+	if input.Title != nil {
+		movie.Title = *input.Title
+	}
+	if input.Year != nil {
+		movie.Year = *input.Year
+	}
+	if input.Runtime != nil {
+		movie.Runtime = *input.Runtime
+	}
+	if input.Genres != nil {
+		movie.Genres = input.Genres
+	}
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.auditedModels(r).Movies.Update(movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict) && input.Version == nil:
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	movieWithEnvelop := map[string]any{
+		"movie": movie,
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, movieWithEnvelop, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -208,25 +445,98 @@ func (app *application) HandleMoviePut(w http.ResponseWriter, r *http.Request) {
 // HandleMovieDelete is the handler for the delete a specific movie endpoint
 //
 //	@Summary		Delete a specific movie
-//	@Description	Delete a specific movie
+//	@Description	Delete a specific movie. The request must carry the ETag of the movie in an If-Match
+//	@Description	header: 428 is returned without one and 412 when it is stale.
 //	@Tags			movies
-//	@Param			id	path	string	false	"movie ID"
-//	@Produce		json
-//	@Success		200	{object}	data.Movie
+//	@Param			id			path	string	false	"movie ID"
+//	@Param			If-Match	header	string	true	"ETag of the movie being deleted"
+//	@Param			X-Actor		header	string	false	"actor recorded in the audit log"
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
+//	@Success		204
 //	@Failure		400	{object}	error
 //	@Failure		404	{object}	error
+//	@Failure		412	{object}	error
+//	@Failure		428	{object}	error
 //	@Failure		500	{object}	error
 //	@Router			/v1/movies/{id} [delete]
 func (app *application) HandleMovieDelete(w http.ResponseWriter, r *http.Request) {
 	var err error
 
-	_, err = app.readMovieIDParam(r)
+	var id int64
+	id, err = app.readMovieIDParam(r)
 	if err != nil {
 		app.logError(r, err)
 		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkIfMatch(w, r, movie, false) {
+		return
+	}
+
+	err = app.auditedModels(r).Movies.DeleteVersion(id, movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// A 204 response must not carry a body, so only the status is written.
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleMovieAutocomplete is the handler for the movie title autocomplete endpoint
+//
+//	@Summary		Autocomplete movie titles
+//	@Description	Returns the id, title and year of movies whose title starts with the given
+//	@Description	prefix, ignoring case.
+//	@Tags			movies
+//	@Param			prefix	query	string	true	"title prefix"
+//	@Param			limit	query	int		false	"maximum number of suggestions (1 to 50)"
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
+//	@Success		200	{array}		data.TitleSuggestion
+//	@Failure		422	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/v1/movies/autocomplete [get]
+func (app *application) HandleMovieAutocomplete(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	prefix := app.readString(qs, "prefix", "")
+	limit := app.readInt(qs, "limit", 10, v)
+
+	v.Check(prefix != "", "prefix", "must be provided")
+	v.Check(len(prefix) <= 100, "prefix", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 50, "limit", "must be a maximum of 50")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Movies.Autocomplete(prefix, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	err = app.writeJSON(w, http.StatusNoContent, nil, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelop{"suggestions": suggestions}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/moviesio"
+	"github.com/yanglyu520/movies-golang-web-api/internal/validator"
+)
+
+// HandleMovieImport is the handler for the bulk movie import endpoint
+//
+//	@Summary		Import movies
+//	@Description	Streams movies from a text/csv upload (with a title,year,runtime,genres header
+//	@Description	row and genres separated by "|") or an application/x-ndjson upload. Every row
+//	@Description	is validated, valid rows are inserted in batches of 500 per transaction, and
+//	@Description	the response reports the outcome of every line. With dry_run=true nothing is
+//	@Description	inserted.
+//	@Tags			movies
+//	@Accept			text/csv,application/x-ndjson
+//	@Param			dry_run	query	bool	false	"validate without inserting"
+//	@Param			X-Actor	header	string	false	"actor recorded in the audit log"
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
+//	@Success		200	{object}	moviesio.Report
+//	@Failure		400	{object}	error
+//	@Failure		415	{object}	error
+//	@Failure		422	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/v1/movies/import [post]
+func (app *application) HandleMovieImport(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	dryRun := app.readBool(r.URL.Query(), "dry_run", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var (
+		reader moviesio.Reader
+		err    error
+	)
+	switch mediaType {
+	case "text/csv":
+		reader, err = moviesio.NewCSVReader(r.Body)
+		if err != nil {
+			app.logError(r, err)
+			v.AddError("header", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	case "application/x-ndjson", "application/ndjson":
+		reader = moviesio.NewNDJSONReader(r.Body)
+	default:
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+
+	// An import runs for as long as the upload takes, so the server read and write timeouts,
+	// which are meant for regular requests, are lifted.
+	app.clearDeadlines(w, r)
+
+	movies := app.auditedModels(r).Movies
+	report := moviesio.Import(r.Context(), reader, dryRun, movies.InsertBatch, func(err error) {
+		app.logError(r, err)
+	})
+
+	status := http.StatusOK
+	if report.Error != "" {
+		status = http.StatusBadRequest
+	}
+
+	err = app.writeResponse(w, r, status, report, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
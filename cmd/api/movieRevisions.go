@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	"github.com/yanglyu520/movies-golang-web-api/internal/validator"
+)
+
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	version, err := strconv.ParseInt(chi.URLParam(r, "version"), 10, 32)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid version parameter: %w", err)
+	}
+	return int32(version), nil
+}
+
+// HandleMovieRevisionList is the handler for the movie revision history endpoint
+//
+//	@Summary		Get the revisions of a movie
+//	@Description	Get every stored version of a movie, newest first.
+//	@Tags			movies
+//	@Param			id			path	string	false	"movie ID"
+//	@Param			page		query	int		false	"page"
+//	@Param			page_size	query	int		false	"page size"
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
+//	@Success		200	{object}	data.RevisionList
+//	@Failure		404	{object}	error
+//	@Failure		422	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/v1/movies/{id}/revisions [get]
+func (app *application) HandleMovieRevisionList(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readMovieIDParam(r)
+	if err != nil {
+		app.logError(r, err)
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var filters data.Filters
+
+	v := validator.New()
+	qs := r.URL.Query()
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = "-version"
+	filters.SortSafelist = []string{"-version"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions, metadata, err := app.models.Movies.Revisions(id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Every movie has at least one revision, so none at all means there is no such movie.
+	if metadata.TotalRecords == 0 && filters.Page == 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, data.RevisionList{Revisions: revisions, Metadata: metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// HandleMovieRevisionGet is the handler for the single movie revision endpoint
+//
+//	@Summary		Get a revision of a movie
+//	@Description	Get a movie as it was at the given version.
+//	@Tags			movies
+//	@Param			id		path	string	false	"movie ID"
+//	@Param			version	path	int		false	"movie version"
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
+//	@Success		200	{object}	data.MovieRevision
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/v1/movies/{id}/revisions/{version} [get]
+func (app *application) HandleMovieRevisionGet(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readMovieIDParam(r)
+	if err != nil {
+		app.logError(r, err)
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.readVersionParam(r)
+	if err != nil {
+		app.logError(r, err)
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	revision, err := app.models.Movies.GetRevision(id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelop{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// HandleMovieRevert is the handler for the movie revert endpoint
+//
+//	@Summary		Revert a movie to a previous version
+//	@Description	Restores the content of a previous version of a movie, which is saved as a new
+//	@Description	version. Like an update, the request must either carry the ETag of the movie in
+//	@Description	an If-Match header (412 when it is stale) or its current version in the
+//	@Description	expected_version body field (409 when it is stale).
+//	@Tags			movies
+//	@Accept			json
+//	@Param			id				path	string	false	"movie ID"
+//	@Param			If-Match		header	string	false	"ETag of the movie being reverted"
+//	@Param			Idempotency-Key	header	string	false	"unique key identifying this request"
+//	@Param			X-Actor			header	string	false	"actor recorded in the audit log"
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
+//	@Success		200	{object}	data.Movie
+//	@Failure		400	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		409	{object}	error
+//	@Failure		412	{object}	error
+//	@Failure		422	{object}	error
+//	@Failure		428	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/v1/movies/{id}/revert [post]
+func (app *application) HandleMovieRevert(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readMovieIDParam(r)
+	if err != nil {
+		app.logError(r, err)
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		// Version is the version to restore.
+		Version int32 `json:"version"`
+		// ExpectedVersion optionally carries the current version, as an alternative to If-Match.
+		ExpectedVersion *int32 `json:"expected_version"`
+	}
+	err = app.readJSONInput(w, r, &input)
+	if err != nil {
+		app.logError(r, err)
+		app.badRequestResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Version > 0 && input.Version < math.MaxInt32, "version", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if input.ExpectedVersion != nil && *input.ExpectedVersion != movie.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+	if !app.checkIfMatch(w, r, movie, input.ExpectedVersion != nil) {
+		return
+	}
+
+	v.Check(input.Version != movie.Version, "version", "must not be the current version")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.auditedModels(r).Movies.Revert(movie, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("version", "no such version")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict) && input.ExpectedVersion == nil:
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeResponse(w, r, http.StatusOK, envelop{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+)
+
+const (
+	// outboxBatchSize is the number of events claimed at a time. They are published one after
+	// the other, so it is kept small to keep the lease short.
+	outboxBatchSize = 10
+	// outboxPublishTimeout bounds a single attempt to publish an event.
+	outboxPublishTimeout = 10 * time.Second
+	// outboxLease is how long claimed events are left alone by other relays. It outlasts
+	// publishing a whole batch with every attempt timing out, so that a slow sink does not get
+	// events of the batch published twice.
+	outboxLease = outboxBatchSize*outboxPublishTimeout + time.Minute
+
+	outboxBaseBackoff = time.Second
+	outboxMaxBackoff  = 5 * time.Minute
+)
+
+// outboxSink is where the relay publishes outbox events. Publish returns once the event is
+// durably accepted; events may be published more than once, so consumers should deduplicate
+// them by id.
+type outboxSink interface {
+	Publish(ctx context.Context, ev *data.OutboxEvent) error
+}
+
+// newOutboxSink returns the sink described by spec: stdout, file:<path> or an http(s) URL.
+func newOutboxSink(spec string) (outboxSink, error) {
+	switch {
+	case spec == "stdout":
+		return &writerSink{w: os.Stdout}, nil
+
+	case strings.HasPrefix(spec, "file:"):
+		path := strings.TrimPrefix(spec, "file:")
+		if path == "" {
+			return nil, fmt.Errorf("outbox sink %q: missing file path", spec)
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		return &writerSink{w: f, sync: f.Sync}, nil
+
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		u, err := url.Parse(spec)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("outbox sink %q: invalid URL", spec)
+		}
+		return &httpSink{url: u.String(), client: &http.Client{Timeout: outboxPublishTimeout}}, nil
+	}
+
+	return nil, fmt.Errorf("outbox sink %q: must be stdout, file:<path> or an http(s) URL", spec)
+}
+
+// writerSink writes events as newline delimited JSON. When sync is set, it is called after every
+// event, so that an event is on disk before it is marked as published.
+type writerSink struct {
+	mu   sync.Mutex
+	w    io.Writer
+	sync func() error
+}
+
+func (s *writerSink) Publish(_ context.Context, ev *data.OutboxEvent) error {
+	js, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.w.Write(append(js, '\n')); err != nil {
+		return err
+	}
+	if s.sync != nil {
+		return s.sync()
+	}
+	return nil
+}
+
+// httpSink posts every event as JSON to a URL. The event id is sent as the Idempotency-Key, so
+// that a receiver can drop the events it was sent before.
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) Publish(ctx context.Context, ev *data.OutboxEvent) error {
+	js, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "movies-golang-web-api/"+version)
+	req.Header.Set("Idempotency-Key", "outbox-"+strconv.FormatInt(ev.ID, 10))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// Reading the rest of the body lets the connection be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// relayOutbox publishes the events of the outbox to sink every interval. An event is only marked
+// as published once the sink accepted it, so an event is published again if the relay stops in
+// between. Claim hands out one event per movie at a time, which keeps the events of a movie in
+// the order they were made, also across several instances of the API.
+func (app *application) relayOutbox(sink outboxSink, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastPurge := time.Now()
+
+	for range ticker.C {
+		for {
+			events, err := app.models.Outbox.Claim(outboxBatchSize, outboxLease)
+			if err != nil {
+				app.logger.Error(err.Error())
+				break
+			}
+
+			published := 0
+			for _, ev := range events {
+				if app.publishOutboxEvent(sink, ev) {
+					published++
+				}
+			}
+
+			// The next event of a movie only becomes claimable once the previous one is
+			// published, so another round may find more.
+			if published == 0 {
+				break
+			}
+		}
+
+		if time.Since(lastPurge) >= time.Hour {
+			lastPurge = time.Now()
+			n, err := app.models.Outbox.DeletePublished(time.Now().Add(-app.cfg.outbox.retention))
+			if err != nil {
+				app.logger.Error(err.Error())
+			} else if n > 0 {
+				app.logger.Info("purged published outbox events", "count", n)
+			}
+		}
+	}
+}
+
+// publishOutboxEvent publishes an event and records the outcome. It reports whether the event
+// was published.
+func (app *application) publishOutboxEvent(sink outboxSink, ev *data.OutboxEvent) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), outboxPublishTimeout)
+	defer cancel()
+
+	if err := sink.Publish(ctx, ev); err != nil {
+		app.logger.Info("outbox publish failed", "event", ev.ID, "movie", ev.AggregateID,
+			"attempts", ev.Attempts, "error", err.Error())
+
+		next := time.Now().Add(outboxBackoff(ev.Attempts))
+		if err = app.models.Outbox.MarkFailed(ev.ID, err.Error(), next); err != nil {
+			app.logger.Error(err.Error())
+		}
+		return false
+	}
+
+	if err := app.models.Outbox.MarkPublished(ev.ID); err != nil {
+		// The lease runs out and the event is published again.
+		app.logger.Error(err.Error())
+		return false
+	}
+	return true
+}
+
+// outboxBackoff returns the delay before publishing an event that failed attempts times again.
+// Events are retried forever: giving up on one would break the order of its movie's events.
+func outboxBackoff(attempts int) time.Duration {
+	if attempts > 20 {
+		return outboxMaxBackoff
+	}
+	return min(outboxBaseBackoff<<max(attempts-1, 0), outboxMaxBackoff)
+}
@@ -30,3 +30,28 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	app.genericErrorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
+
+// send 409 conflict when the resource was modified since the client last read it
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	app.genericErrorResponse(w, r, http.StatusConflict, errEditConflictMessage)
+}
+
+// send 406 not acceptable when none of the formats in the Accept header can be produced
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	app.genericErrorResponse(w, r, http.StatusNotAcceptable, errNotAcceptableMessage)
+}
+
+// send 415 unsupported media type when the request body is in a format the endpoint cannot read
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	app.genericErrorResponse(w, r, http.StatusUnsupportedMediaType, errUnsupportedMediaMessage)
+}
+
+// send 412 precondition failed when the If-Match header does not match the current resource
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	app.genericErrorResponse(w, r, http.StatusPreconditionFailed, errPreconditionFailedMessage)
+}
+
+// send 428 precondition required when a write is sent without an If-Match header
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	app.genericErrorResponse(w, r, http.StatusPreconditionRequired, errPreconditionRequiredMessage)
+}
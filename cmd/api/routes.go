@@ -9,6 +9,9 @@ import (
 	"time"
 )
 
+// requestTimeout bounds the handling time of regular requests. Streaming endpoints are exempt.
+const requestTimeout = 60 * time.Second
+
 func (app *application) routes() *chi.Mux {
 	r := chi.NewRouter()
 
@@ -16,19 +19,28 @@ func (app *application) routes() *chi.Mux {
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
+	r.Use(app.compress)
 	r.MethodNotAllowed(app.methodNotAllowedResponse)
 	r.NotFound(app.notFoundResponse)
-	r.Use(middleware.Timeout(60 * time.Second))
 
 	printRoutes(r)
 
 	r.Get("/", app.HandleRootGet)
 
 	r.Route("/v1", func(r chi.Router) {
-		r.HandleFunc("/", app.HandleRootGet)
-		r.Get("/healthcheck", app.handleHealthCheck)
 		r.Mount("/movies", app.movieRouter())
-		app.RouteAPIDocs(r)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.Timeout(requestTimeout))
+			r.HandleFunc("/", app.HandleRootGet)
+			r.Get("/healthcheck", app.handleHealthCheck)
+			r.Get("/health/live", app.HandleHealthLive)
+			r.Get("/health/ready", app.HandleHealthReady)
+			r.Get("/audit", app.HandleAuditList)
+			r.Mount("/webhooks", app.webhookRouter())
+			app.RouteAPIDocs(r)
+			app.RouteGraphQL(r)
+		})
 	})
 
 	return r
@@ -36,12 +48,27 @@ func (app *application) routes() *chi.Mux {
 
 func (app *application) movieRouter() http.Handler {
 	r := chi.NewRouter()
-	r.Get("/", app.HandleMovieList)
-	r.Post("/", app.HandleMoviePost)
-	r.Route("/{id}", func(r chi.Router) {
-		r.Get("/", app.HandleMovieGet)
-		r.Put("/", app.HandleMoviePut)
-		r.Delete("/", app.HandleMovieDelete)
+
+	// Streaming endpoints run for as long as the transfer takes.
+	r.Post("/import", app.HandleMovieImport)
+	r.Get("/export", app.HandleMovieExport)
+	r.Get("/events", app.HandleMovieEvents)
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(requestTimeout))
+		r.Get("/", app.HandleMovieList)
+		r.With(app.idempotent).Post("/", app.HandleMoviePost)
+		r.With(app.idempotent).Post("/batch", app.HandleMovieBatch)
+		r.Get("/autocomplete", app.HandleMovieAutocomplete)
+		r.Route("/{id}", func(r chi.Router) {
+			r.Get("/", app.HandleMovieGet)
+			r.Put("/", app.HandleMoviePut)
+			r.With(app.idempotent).Patch("/", app.HandleMoviePatch)
+			r.Delete("/", app.HandleMovieDelete)
+			r.Get("/revisions", app.HandleMovieRevisionList)
+			r.Get("/revisions/{version}", app.HandleMovieRevisionGet)
+			r.With(app.idempotent).Post("/revert", app.HandleMovieRevert)
+		})
 	})
 	return r
 }
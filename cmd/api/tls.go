@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// tlsReloader holds the TLS configuration built from the certificate, key and client CA files,
+// and rebuilds it when any of them changes on disk, so that renewed certificates are served
+// without a restart.
+type tlsReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	current atomic.Pointer[tls.Config]
+	// modTimes are the modification times of the files at the last load attempt. They are only
+	// used by watchTLSFiles.
+	modTimes []time.Time
+}
+
+func newTLSReloader(certFile, keyFile, clientCAFile string) (*tlsReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("-tls-cert and -tls-key must be given together")
+	}
+
+	t := &tlsReloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	t.modTimes = t.stat()
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *tlsReloader) files() []string {
+	files := []string{t.certFile, t.keyFile}
+	if t.clientCAFile != "" {
+		files = append(files, t.clientCAFile)
+	}
+	return files
+}
+
+// stat returns the modification times of the files, zero for those that cannot be read.
+func (t *tlsReloader) stat() []time.Time {
+	files := t.files()
+	modTimes := make([]time.Time, len(files))
+	for i, name := range files {
+		if info, err := os.Stat(name); err == nil {
+			modTimes[i] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+// load builds the configuration from the files and makes it current.
+func (t *tlsReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		// TLS 1.3 suites are not configurable; for TLS 1.2 only forward secret AEAD suites are
+		// offered.
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	if t.clientCAFile != "" {
+		pem, err := os.ReadFile(t.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("loading TLS client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("loading TLS client CA: no certificates found in %s", t.clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	t.current.Store(config)
+	return nil
+}
+
+// config returns the configuration to serve with. Every handshake uses the configuration
+// current at that time.
+func (t *tlsReloader) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return t.current.Load(), nil
+		},
+	}
+}
+
+// watchTLSFiles reloads the configuration of t whenever one of its files changes, checking every
+// interval. A change that fails to load, such as a certificate written before its key, is logged
+// and the previous configuration kept until the files change again.
+func (app *application) watchTLSFiles(t *tlsReloader, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		modTimes := t.stat()
+		if slices.EqualFunc(modTimes, t.modTimes, time.Time.Equal) {
+			continue
+		}
+		t.modTimes = modTimes
+
+		if err := t.load(); err != nil {
+			app.logger.Error(err.Error())
+			continue
+		}
+		app.logger.Info("TLS certificate reloaded", "cert", t.certFile)
+	}
+}
+
+// serveHTTPSRedirect listens for plain HTTP on port and permanently redirects every request to
+// the same URL over HTTPS.
+func (app *application) serveHTTPSRedirect(port int) error {
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      http.HandlerFunc(app.redirectToHTTPS),
+		IdleTimeout:  serverIdleTimeout,
+		ReadTimeout:  serverReadTimeout,
+		WriteTimeout: serverWriteTimeout,
+	}
+
+	app.logger.Info("starting HTTPS redirect server", "port", port)
+
+	return server.ListenAndServe()
+}
+
+func (app *application) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = strings.Trim(r.Host, "[]")
+	}
+	if app.cfg.port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(app.cfg.port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	// 308 keeps the method and body of the request, unlike 301.
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	"github.com/yanglyu520/movies-golang-web-api/internal/validator"
+)
+
+const (
+	// webhookBatchSize is the number of deliveries a worker claims, and attempts concurrently,
+	// at a time.
+	webhookBatchSize = 10
+	// webhookBaseBackoff is the delay before the first retry of a failed delivery. It doubles
+	// with every further attempt, up to webhookMaxBackoff.
+	webhookBaseBackoff = 10 * time.Second
+	webhookMaxBackoff  = 6 * time.Hour
+)
+
+func (app *application) webhookRouter() http.Handler {
+	r := chi.NewRouter()
+
+	r.Get("/", app.HandleWebhookList)
+	r.Post("/", app.HandleWebhookPost)
+	r.Route("/{id}", func(r chi.Router) {
+		r.Get("/", app.HandleWebhookGet)
+		r.Patch("/", app.HandleWebhookPatch)
+		r.Delete("/", app.HandleWebhookDelete)
+		r.Get("/deliveries", app.HandleWebhookDeliveries)
+	})
+	return r
+}
+
+// newWebhookSecret returns a random secret for signing deliveries.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HandleWebhookList is the handler for listing webhook subscriptions
+//
+//	@Summary		Get the webhook subscriptions
+//	@Description	Get every webhook subscription. Secrets are never included.
+//	@Tags			webhooks
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
+//	@Success		200	{array}		data.WebhookSubscription
+//	@Failure		500	{object}	error
+//	@Router			/v1/webhooks [get]
+func (app *application) HandleWebhookList(w http.ResponseWriter, r *http.Request) {
+	subs, err := app.models.Webhooks.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, sub := range subs {
+		sub.Secret = ""
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelop{"webhooks": subs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// HandleWebhookPost is the handler for creating a webhook subscription
+//
+//	@Summary		Create a webhook subscription
+//	@Description	Subscribes a URL to movie.created, movie.updated and movie.deleted events (all of
+//	@Description	them when events is empty). Every delivery is signed with HMAC-SHA256 using the
+//	@Description	secret, which is generated when not given and only ever returned here.
+//	@Tags			webhooks
+//	@Accept			json
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
+//	@Success		201	{object}	data.WebhookSubscription
+//	@Failure		400	{object}	error
+//	@Failure		422	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/v1/webhooks [post]
+func (app *application) HandleWebhookPost(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+
+	err := app.readJSONInput(w, r, &input)
+	if err != nil {
+		app.logError(r, err)
+		app.badRequestResponse(w, r)
+		return
+	}
+
+	sub := &data.WebhookSubscription{
+		URL:    input.URL,
+		Secret: input.Secret,
+		Events: input.Events,
+		Active: input.Active == nil || *input.Active,
+	}
+	if sub.Events == nil {
+		sub.Events = []string{}
+	}
+	if sub.Secret == "" {
+		sub.Secret, err = newWebhookSecret()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+	if data.ValidateWebhookSubscription(v, sub, app.cfg.webhooks.allowPrivate); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Insert(sub)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", sub.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelop{"webhook": sub}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readWebhook fetches the subscription named in the URL, responding with an error when it
+// cannot.
+func (app *application) readWebhook(w http.ResponseWriter, r *http.Request) (*data.WebhookSubscription, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.logError(r, err)
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	sub, err := app.models.Webhooks.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return sub, true
+}
+
+// HandleWebhookGet is the handler for getting a webhook subscription
+//
+//	@Summary		Get a webhook subscription
+//	@Tags			webhooks
+//	@Param			id	path	string	false	"subscription ID"
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
+//	@Success		200	{object}	data.WebhookSubscription
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/v1/webhooks/{id} [get]
+func (app *application) HandleWebhookGet(w http.ResponseWriter, r *http.Request) {
+	sub, ok := app.readWebhook(w, r)
+	if !ok {
+		return
+	}
+	sub.Secret = ""
+
+	err := app.writeResponse(w, r, http.StatusOK, envelop{"webhook": sub}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// HandleWebhookPatch is the handler for updating a webhook subscription
+//
+//	@Summary		Update a webhook subscription
+//	@Description	Update the fields present in the request body. Setting active to false pauses
+//	@Description	the subscription: no events are queued for it until it is activated again.
+//	@Tags			webhooks
+//	@Param			id	path	string	false	"subscription ID"
+//	@Accept			json
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
+//	@Success		200	{object}	data.WebhookSubscription
+//	@Failure		400	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		409	{object}	error
+//	@Failure		422	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/v1/webhooks/{id} [patch]
+func (app *application) HandleWebhookPatch(w http.ResponseWriter, r *http.Request) {
+	sub, ok := app.readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		URL    *string  `json:"url"`
+		Secret *string  `json:"secret"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+
+	err := app.readJSONInput(w, r, &input)
+	if err != nil {
+		app.logError(r, err)
+		app.badRequestResponse(w, r)
+		return
+	}
+
+	if input.URL != nil {
+		sub.URL = *input.URL
+	}
+	if input.Secret != nil {
+		sub.Secret = *input.Secret
+	}
+	if input.Events != nil {
+		sub.Events = input.Events
+	}
+	if input.Active != nil {
+		sub.Active = *input.Active
+	}
+
+	v := validator.New()
+	if data.ValidateWebhookSubscription(v, sub, app.cfg.webhooks.allowPrivate); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Update(sub)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	sub.Secret = ""
+
+	err = app.writeResponse(w, r, http.StatusOK, envelop{"webhook": sub}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// HandleWebhookDelete is the handler for deleting a webhook subscription
+//
+//	@Summary		Delete a webhook subscription
+//	@Description	Delete a webhook subscription along with its delivery log.
+//	@Tags			webhooks
+//	@Param			id	path	string	false	"subscription ID"
+//	@Success		204
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/v1/webhooks/{id} [delete]
+func (app *application) HandleWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.logError(r, err)
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Webhooks.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleWebhookDeliveries is the handler for the delivery log of a webhook subscription
+//
+//	@Summary		Get the delivery log of a webhook subscription
+//	@Description	Get the deliveries queued for a subscription, newest first, with their status
+//	@Description	(pending, delivered or dead), attempt count and last error.
+//	@Tags			webhooks
+//	@Param			id			path	string	false	"subscription ID"
+//	@Param			status		query	string	false	"pending, delivered or dead"
+//	@Param			page		query	int		false	"page"
+//	@Param			page_size	query	int		false	"page size"
+//	@Produce		application/json,application/x-ndjson,text/csv,application/xml,application/msgpack
+//	@Success		200	{object}	data.DeliveryList
+//	@Failure		404	{object}	error
+//	@Failure		422	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/v1/webhooks/{id}/deliveries [get]
+func (app *application) HandleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	sub, ok := app.readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var filters data.Filters
+
+	v := validator.New()
+	qs := r.URL.Query()
+	status := app.readString(qs, "status", "")
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = "-id"
+	filters.SortSafelist = []string{"-id"}
+
+	v.Check(status == "" || validator.PermittedValue(status, data.DeliveryPending, data.DeliveryDelivered, data.DeliveryDead),
+		"status", "must be pending, delivered or dead")
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, metadata, err := app.models.Webhooks.Deliveries(sub.ID, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, data.DeliveryList{Deliveries: deliveries, Metadata: metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deliverWebhooks periodically attempts the webhook deliveries that are due.
+func (app *application) deliverWebhooks(interval time.Duration) {
+	client := &http.Client{
+		Timeout:   app.cfg.webhooks.timeout,
+		Transport: webhookTransport(app.cfg.webhooks.allowPrivate),
+		// A redirect is reported as a failure rather than followed, since the signature was
+		// made for the subscribed URL.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	// A claimed delivery is left alone by other workers for longer than an attempt can take.
+	lease := app.cfg.webhooks.timeout + time.Minute
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			deliveries, err := app.models.Webhooks.ClaimDue(webhookBatchSize, lease)
+			if err != nil {
+				app.logger.Error(err.Error())
+				break
+			}
+
+			var wg sync.WaitGroup
+			for _, d := range deliveries {
+				wg.Add(1)
+				go func(d *data.WebhookDelivery) {
+					defer wg.Done()
+					app.attemptDelivery(client, d)
+				}(d)
+			}
+			wg.Wait()
+
+			if len(deliveries) < webhookBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// webhookTransport returns the transport webhooks are delivered with. Unless allowPrivate is
+// true it refuses to connect to addresses data.PublicWebhookAddr rejects, checking the address a
+// host name resolved to so that DNS cannot be used to get around the URL validation. No proxy is
+// used, since the proxy would be connected to instead of the subscriber.
+func webhookTransport(allowPrivate bool) *http.Transport {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !data.PublicWebhookAddr(addrPort.Addr()) {
+				return fmt.Errorf("webhook address %s is not public", addrPort.Addr())
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// attemptDelivery posts a delivery to its subscription and records the outcome.
+func (app *application) attemptDelivery(client *http.Client, d *data.WebhookDelivery) {
+	statusCode, err := postWebhook(client, d)
+	if err == nil {
+		if err = app.models.Webhooks.MarkDelivered(d.ID, statusCode); err != nil {
+			app.logger.Error(err.Error())
+		}
+		return
+	}
+
+	attempts := d.Attempts + 1
+	var nextAttempt time.Time
+	if attempts < app.cfg.webhooks.maxAttempts {
+		nextAttempt = time.Now().Add(webhookBackoff(attempts))
+	}
+
+	app.logger.Info("webhook delivery failed", "delivery", d.ID, "subscription", d.SubscriptionID,
+		"attempts", attempts, "dead", nextAttempt.IsZero(), "error", err.Error())
+
+	if err = app.models.Webhooks.MarkFailed(d.ID, statusCode, err.Error(), nextAttempt); err != nil {
+		app.logger.Error(err.Error())
+	}
+}
+
+// webhookBackoff returns the delay before the next attempt of a delivery that failed attempts
+// times: an exponentially growing delay with up to 20% of random jitter, so that deliveries
+// failing together do not retry together.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookMaxBackoff
+	if attempts <= 20 {
+		backoff = min(webhookBaseBackoff<<(attempts-1), webhookMaxBackoff)
+	}
+	return backoff + time.Duration(mrand.Int64N(int64(backoff/5)+1))
+}
+
+// postWebhook sends a delivery. It returns the response status, if any, and an error unless the
+// subscriber answered with a 2xx status.
+func postWebhook(client *http.Client, d *data.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "movies-golang-web-api/"+version)
+	req.Header.Set("X-Webhook-Id", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(d.Secret, timestamp, d.Payload))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// Reading the rest of the body lets the connection be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected response status %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// signWebhook returns the hex encoded HMAC-SHA256 of timestamp + "." + payload. Covering the
+// timestamp lets subscribers reject replayed deliveries.
+func signWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
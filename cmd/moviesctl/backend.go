@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	"github.com/yanglyu520/movies-golang-web-api/internal/moviesio"
+)
+
+// backend carries out commands, either directly in the database or through the API.
+type backend interface {
+	List(ctx context.Context, q movieQuery) ([]*data.Movie, data.Metadata, error)
+	Get(ctx context.Context, id int64) (*data.Movie, error)
+	// Create validates and inserts movie, filling in its id and version.
+	Create(ctx context.Context, movie *data.Movie) error
+	// Update applies patch to the movie and returns the result.
+	Update(ctx context.Context, id int64, patch moviePatch) (*data.Movie, error)
+	// Delete deletes the movie; when version is given, only if it still has that version.
+	Delete(ctx context.Context, id int64, version *int32) error
+	// Import inserts the movies of a csv or ndjson file, in the formats of POST /v1/movies/import.
+	Import(ctx context.Context, r io.Reader, format string, dryRun bool) (*moviesio.Report, error)
+	// Export writes the movies matching q as csv or ndjson, like GET /v1/movies/export.
+	Export(ctx context.Context, w io.Writer, q movieQuery, format string) error
+	// Seed loads generated or fixture movies as fast as the backend allows and returns how many
+	// were stored.
+	Seed(ctx context.Context, movies []*data.Movie) (int, error)
+	Health(ctx context.Context) (map[string]any, error)
+	Close() error
+}
+
+// movieQuery selects movies like the query string of GET /v1/movies.
+type movieQuery struct {
+	Title    string
+	Genres   []string
+	Page     int
+	PageSize int
+	Sort     string
+	Fuzzy    bool
+}
+
+// moviePatch holds the fields to change in an update; nil fields are left alone. Version is the
+// expected version of the movie.
+type moviePatch struct {
+	Title   *string  `json:"title,omitempty"`
+	Year    *int32   `json:"year,omitempty"`
+	Runtime *int32   `json:"runtime,omitempty"`
+	Genres  []string `json:"genres"`
+	Version *int32   `json:"version,omitempty"`
+}
+
+func (patch moviePatch) apply(movie *data.Movie) {
+	if patch.Title != nil {
+		movie.Title = *patch.Title
+	}
+	if patch.Year != nil {
+		movie.Year = *patch.Year
+	}
+	if patch.Runtime != nil {
+		movie.Runtime = *patch.Runtime
+	}
+	if patch.Genres != nil {
+		movie.Genres = patch.Genres
+	}
+}
+
+// validationError reports the fields of a movie or query that failed validation.
+type validationError map[string]string
+
+func (e validationError) Error() string {
+	return "invalid input: " + formatFieldErrors(e)
+}
+
+func formatFieldErrors(errs map[string]string) string {
+	fields := make([]string, 0, len(errs))
+	for field := range errs {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		parts[i] = fmt.Sprintf("%s %s", field, errs[field])
+	}
+	return strings.Join(parts, "; ")
+}
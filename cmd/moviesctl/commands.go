@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	"github.com/yanglyu520/movies-golang-web-api/internal/seed"
+)
+
+// runFunc runs a command with its positional arguments.
+type runFunc func(ctx context.Context, b backend, p *printer, args []string) error
+
+type command struct {
+	// setup defines the flags of the command and returns the function running it.
+	setup func(fs *flag.FlagSet) runFunc
+	// streaming commands run for as long as the transfer takes, without a timeout.
+	streaming bool
+	// offline commands need neither the database nor the API, and run without a backend.
+	offline bool
+	// unsupported, when set, is the error the command fails with.
+	unsupported string
+}
+
+const errNoUsers = "users and permissions are not supported: the movies API has no user accounts or permissions to manage"
+
+var commands = map[string]command{
+	"list":        {setup: listCommand},
+	"get":         {setup: getCommand},
+	"create":      {setup: createCommand},
+	"update":      {setup: updateCommand},
+	"delete":      {setup: deleteCommand},
+	"import":      {setup: importCommand, streaming: true},
+	"export":      {setup: exportCommand, streaming: true},
+	"health":      {setup: healthCommand},
+	"seed":        {setup: seedCommand, streaming: true},
+	"generate":    {setup: generateCommand, streaming: true, offline: true},
+	"users":       {unsupported: errNoUsers},
+	"permissions": {unsupported: errNoUsers},
+}
+
+func listCommand(fs *flag.FlagSet) runFunc {
+	var q movieQuery
+	var genres string
+	fs.StringVar(&q.Title, "title", "", "title search")
+	fs.StringVar(&genres, "genres", "", "comma separated genres")
+	fs.IntVar(&q.Page, "page", 1, "page number")
+	fs.IntVar(&q.PageSize, "page-size", 20, "movies per page, at most 100")
+	fs.StringVar(&q.Sort, "sort", "id", "sort order: id, title, year or runtime, prefixed with - for descending")
+	fs.BoolVar(&q.Fuzzy, "fuzzy", false, "typo-tolerant title search")
+
+	return func(ctx context.Context, b backend, p *printer, args []string) error {
+		if len(args) > 0 {
+			return errUsage
+		}
+		q.Genres = splitList(genres)
+
+		movies, metadata, err := b.List(ctx, q)
+		if err != nil {
+			return err
+		}
+		return p.movieList(movies, metadata)
+	}
+}
+
+func getCommand(fs *flag.FlagSet) runFunc {
+	return func(ctx context.Context, b backend, p *printer, args []string) error {
+		id, err := movieIDArg(args)
+		if err != nil {
+			return err
+		}
+
+		movie, err := b.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		return p.movie(movie)
+	}
+}
+
+func createCommand(fs *flag.FlagSet) runFunc {
+	var movie data.Movie
+	var year, runtime int
+	var genres string
+	fs.StringVar(&movie.Title, "title", "", "title (required)")
+	fs.IntVar(&year, "year", 0, "release year (required)")
+	fs.IntVar(&runtime, "runtime", 0, "runtime in minutes (required)")
+	fs.StringVar(&genres, "genres", "", "comma separated genres (required)")
+
+	return func(ctx context.Context, b backend, p *printer, args []string) error {
+		if len(args) > 0 {
+			return errUsage
+		}
+		movie.Year, movie.Runtime = int32(year), int32(runtime)
+		movie.Genres = splitList(genres)
+
+		if err := b.Create(ctx, &movie); err != nil {
+			return err
+		}
+		return p.movie(&movie)
+	}
+}
+
+func updateCommand(fs *flag.FlagSet) runFunc {
+	var title, genres string
+	var year, runtime, version int
+	fs.StringVar(&title, "title", "", "new title")
+	fs.IntVar(&year, "year", 0, "new release year")
+	fs.IntVar(&runtime, "runtime", 0, "new runtime in minutes")
+	fs.StringVar(&genres, "genres", "", "new comma separated genres")
+	fs.IntVar(&version, "version", 0, "only update the movie if it still has this version")
+
+	return func(ctx context.Context, b backend, p *printer, args []string) error {
+		id, err := movieIDArg(args)
+		if err != nil {
+			return err
+		}
+
+		// Only the flags given on the command line are changed.
+		var patch moviePatch
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "title":
+				patch.Title = &title
+			case "year":
+				y := int32(year)
+				patch.Year = &y
+			case "runtime":
+				r := int32(runtime)
+				patch.Runtime = &r
+			case "genres":
+				patch.Genres = splitList(genres)
+				if patch.Genres == nil {
+					patch.Genres = []string{}
+				}
+			case "version":
+				v := int32(version)
+				patch.Version = &v
+			}
+		})
+
+		movie, err := b.Update(ctx, id, patch)
+		if err != nil {
+			return err
+		}
+		return p.movie(movie)
+	}
+}
+
+func deleteCommand(fs *flag.FlagSet) runFunc {
+	var version int
+	fs.IntVar(&version, "version", 0, "only delete the movie if it still has this version")
+
+	return func(ctx context.Context, b backend, p *printer, args []string) error {
+		id, err := movieIDArg(args)
+		if err != nil {
+			return err
+		}
+
+		var expected *int32
+		if version != 0 {
+			v := int32(version)
+			expected = &v
+		}
+
+		if err = b.Delete(ctx, id, expected); err != nil {
+			return err
+		}
+		return p.message(fmt.Sprintf("movie %d deleted", id), map[string]any{"id": id, "deleted": true})
+	}
+}
+
+func importCommand(fs *flag.FlagSet) runFunc {
+	var format string
+	var dryRun bool
+	fs.StringVar(&format, "format", "", "csv or ndjson; guessed from the file extension by default")
+	fs.BoolVar(&dryRun, "dry-run", false, "validate the rows without inserting them")
+
+	return func(ctx context.Context, b backend, p *printer, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+
+		format, err := fileFormat(format, args[0])
+		if err != nil {
+			return err
+		}
+
+		var r io.Reader = os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+
+		report, err := b.Import(ctx, r, format, dryRun)
+		if err != nil {
+			return err
+		}
+		if err = p.importReport(report); err != nil {
+			return err
+		}
+		if report.Error != "" {
+			return errors.New(report.Error)
+		}
+		return nil
+	}
+}
+
+func exportCommand(fs *flag.FlagSet) runFunc {
+	var q movieQuery
+	var genres, format, out string
+	fs.StringVar(&q.Title, "title", "", "title search")
+	fs.StringVar(&genres, "genres", "", "comma separated genres")
+	fs.BoolVar(&q.Fuzzy, "fuzzy", false, "typo-tolerant title search")
+	fs.StringVar(&format, "format", "", "csv or ndjson; guessed from the -out extension, ndjson by default")
+	fs.StringVar(&out, "out", "-", "file to write, - for stdout")
+
+	return func(ctx context.Context, b backend, p *printer, args []string) error {
+		if len(args) > 0 {
+			return errUsage
+		}
+		q.Genres = splitList(genres)
+
+		if format == "" && out == "-" {
+			format = "ndjson"
+		}
+		format, err := fileFormat(format, out)
+		if err != nil {
+			return err
+		}
+
+		w := p.w
+		if out != "-" {
+			f, err := os.Create(out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+
+		return b.Export(ctx, w, q, format)
+	}
+}
+
+func healthCommand(fs *flag.FlagSet) runFunc {
+	return func(ctx context.Context, b backend, p *printer, args []string) error {
+		if len(args) > 0 {
+			return errUsage
+		}
+
+		health, err := b.Health(ctx)
+		if err != nil {
+			return err
+		}
+		return p.keyValues(health)
+	}
+}
+
+// seedBatchSize is the number of generated movies loaded at a time.
+const seedBatchSize = 5000
+
+// seedFlags defines the flags choosing the movies of seed and generate, and returns a function
+// producing them in batches; it returns nil once all were produced.
+func seedFlags(fs *flag.FlagSet) func() ([]*data.Movie, error) {
+	var n, maxYear int
+	var seedValue uint64
+	var fixture string
+	fs.IntVar(&n, "n", 1000, "number of movies to generate")
+	fs.Uint64Var(&seedValue, "seed", 1, "seed of the generator; the same seed generates the same movies")
+	fs.IntVar(&maxYear, "max-year", 0, "latest release year generated, the current year by default")
+	fs.StringVar(&fixture, "fixture", "", "load a fixed set instead: "+strings.Join(seed.FixtureNames(), ", "))
+
+	var g *seed.Generator
+	done := false
+	return func() ([]*data.Movie, error) {
+		if done {
+			return nil, nil
+		}
+
+		if fixture != "" {
+			done = true
+			movies, ok := seed.Fixture(fixture)
+			if !ok {
+				return nil, fmt.Errorf("unknown fixture %q: use one of %s", fixture, strings.Join(seed.FixtureNames(), ", "))
+			}
+			return movies, nil
+		}
+
+		if g == nil {
+			if n < 0 || (maxYear != 0 && maxYear < seed.MinYear) {
+				return nil, errUsage
+			}
+			g = seed.New(seedValue)
+			g.MaxYear = int32(maxYear)
+		}
+		batch := min(n, seedBatchSize)
+		n -= batch
+		done = n == 0
+		if batch == 0 {
+			return nil, nil
+		}
+		return g.Movies(batch), nil
+	}
+}
+
+func seedCommand(fs *flag.FlagSet) runFunc {
+	next := seedFlags(fs)
+
+	return func(ctx context.Context, b backend, p *printer, args []string) error {
+		if len(args) > 0 {
+			return errUsage
+		}
+
+		seeded := 0
+		for {
+			movies, err := next()
+			if err != nil {
+				return err
+			}
+			if movies == nil {
+				break
+			}
+
+			n, err := b.Seed(ctx, movies)
+			seeded += n
+			if err != nil {
+				return fmt.Errorf("after seeding %d movies: %w", seeded, err)
+			}
+		}
+		return p.message(fmt.Sprintf("seeded %d movies", seeded), map[string]any{"seeded": seeded})
+	}
+}
+
+// generateCommand writes the movies seed would load as NDJSON, in the format of import.
+func generateCommand(fs *flag.FlagSet) runFunc {
+	next := seedFlags(fs)
+
+	return func(ctx context.Context, b backend, p *printer, args []string) error {
+		if len(args) > 0 {
+			return errUsage
+		}
+
+		w := bufio.NewWriter(p.w)
+		for {
+			movies, err := next()
+			if err != nil {
+				return err
+			}
+			if movies == nil {
+				break
+			}
+			if err = writeNDJSON(w, movies); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	}
+}
+
+// writeNDJSON writes movies in the NDJSON format of import, one movie per line without its id
+// and version.
+func writeNDJSON(w io.Writer, movies []*data.Movie) error {
+	enc := json.NewEncoder(w)
+	for _, movie := range movies {
+		err := enc.Encode(map[string]any{
+			"title":   movie.Title,
+			"year":    movie.Year,
+			"runtime": movie.Runtime,
+			"genres":  movie.Genres,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func movieIDArg(args []string) (int64, error) {
+	if len(args) != 1 {
+		return 0, errUsage
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid movie id %q", args[0])
+	}
+	return id, nil
+}
+
+// fileFormat returns the given import or export format, or the one matching the extension of
+// name.
+func fileFormat(format, name string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+		if format == "jsonl" {
+			format = "ndjson"
+		}
+	}
+	if format != "csv" && format != "ndjson" {
+		return "", fmt.Errorf("unknown file format %q: use -format csv or -format ndjson", format)
+	}
+	return format, nil
+}
+
+func splitList(s string) []string {
+	var values []string
+	for _, value := range strings.Split(s, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	"github.com/yanglyu520/movies-golang-web-api/internal/migrate"
+	"github.com/yanglyu520/movies-golang-web-api/internal/moviesio"
+	"github.com/yanglyu520/movies-golang-web-api/internal/validator"
+	"github.com/yanglyu520/movies-golang-web-api/migrations"
+)
+
+var (
+	errNotFound = errors.New("the movie could not be found")
+	errConflict = errors.New("the movie was changed by someone else, check its current version and try again")
+)
+
+// movieSortSafelist holds the sort values accepted by GET /v1/movies.
+var movieSortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+// dbBackend works on the database directly through internal/data, so it needs no running API.
+type dbBackend struct {
+	db     *sql.DB
+	models data.Models
+}
+
+func newDBBackend(dsn, actor string, outbox bool) (*dbBackend, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	models := data.NewModels(db).WithAuditor(data.Auditor{Actor: actor, RequestID: "moviesctl"})
+	models.Movies.Outbox = outbox
+	return &dbBackend{db: db, models: models}, nil
+}
+
+func (b *dbBackend) Close() error {
+	return b.db.Close()
+}
+
+func (b *dbBackend) List(ctx context.Context, q movieQuery) ([]*data.Movie, data.Metadata, error) {
+	filters := data.Filters{
+		Page:         q.Page,
+		PageSize:     q.PageSize,
+		Sort:         q.Sort,
+		SortSafelist: movieSortSafelist,
+		Fuzzy:        q.Fuzzy,
+		Similarity:   data.DefaultSimilarity,
+	}
+
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		return nil, data.Metadata{}, validationError(v.Errors)
+	}
+
+	return b.models.Movies.GetAll(ctx, q.Title, q.Genres, filters)
+}
+
+func (b *dbBackend) Get(ctx context.Context, id int64) (*data.Movie, error) {
+	movie, err := b.models.Movies.Get(id)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return nil, errNotFound
+	}
+	return movie, err
+}
+
+func (b *dbBackend) Create(ctx context.Context, movie *data.Movie) error {
+	movie.CreatedAt = time.Now().Unix()
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return validationError(v.Errors)
+	}
+
+	return b.models.Movies.Insert(movie)
+}
+
+func (b *dbBackend) Update(ctx context.Context, id int64, patch moviePatch) (*data.Movie, error) {
+	movie, err := b.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if patch.Version != nil && *patch.Version != movie.Version {
+		return nil, errConflict
+	}
+
+	patch.apply(movie)
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return nil, validationError(v.Errors)
+	}
+
+	err = b.models.Movies.Update(movie)
+	if errors.Is(err, data.ErrEditConflict) {
+		return nil, errConflict
+	}
+	return movie, err
+}
+
+func (b *dbBackend) Delete(ctx context.Context, id int64, version *int32) error {
+	var err error
+	if version != nil {
+		err = b.models.Movies.DeleteVersion(id, *version)
+	} else {
+		err = b.models.Movies.Delete(id)
+	}
+
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return errNotFound
+	case errors.Is(err, data.ErrEditConflict):
+		return errConflict
+	}
+	return err
+}
+
+func (b *dbBackend) Import(ctx context.Context, r io.Reader, format string, dryRun bool) (*moviesio.Report, error) {
+	var reader moviesio.Reader
+	switch format {
+	case "csv":
+		cr, err := moviesio.NewCSVReader(r)
+		if err != nil {
+			return nil, err
+		}
+		reader = cr
+	default:
+		reader = moviesio.NewNDJSONReader(r)
+	}
+
+	return moviesio.Import(ctx, reader, dryRun, b.models.Movies.InsertBatch, nil), nil
+}
+
+func (b *dbBackend) Export(ctx context.Context, w io.Writer, q movieQuery, format string) error {
+	filters := data.Filters{Fuzzy: q.Fuzzy, Similarity: data.DefaultSimilarity}
+
+	bw := bufio.NewWriter(w)
+
+	if format == "ndjson" {
+		enc := json.NewEncoder(bw)
+		err := b.models.Movies.Export(ctx, q.Title, q.Genres, filters, func(movie *data.Movie) error {
+			return enc.Encode(movie)
+		})
+		if err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+
+	cw := csv.NewWriter(bw)
+	if err := cw.Write([]string{"id", "title", "year", "runtime", "genres", "version"}); err != nil {
+		return err
+	}
+	err := b.models.Movies.Export(ctx, q.Title, q.Genres, filters, func(movie *data.Movie) error {
+		return cw.Write([]string{
+			strconv.FormatInt(movie.ID, 10),
+			movie.Title,
+			strconv.Itoa(int(movie.Year)),
+			strconv.Itoa(int(movie.Runtime)),
+			strings.Join(movie.Genres, moviesio.ListSeparator),
+			strconv.Itoa(int(movie.Version)),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	if err = cw.Error(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Seed validates the movies and loads them with COPY, skipping the audit log, webhooks and the
+// outbox; see data.MovieModel.CopyIn.
+func (b *dbBackend) Seed(ctx context.Context, movies []*data.Movie) (int, error) {
+	createdAt := time.Now().Unix()
+	for _, movie := range movies {
+		movie.CreatedAt = createdAt
+
+		v := validator.New()
+		if data.ValidateMovie(v, movie); !v.Valid() {
+			return 0, fmt.Errorf("movie %q: %w", movie.Title, validationError(v.Errors))
+		}
+	}
+
+	if err := b.models.Movies.CopyIn(ctx, movies); err != nil {
+		return 0, err
+	}
+	return len(movies), nil
+}
+
+func (b *dbBackend) Health(ctx context.Context) (map[string]any, error) {
+	if err := b.db.PingContext(ctx); err != nil {
+		return nil, err
+	}
+
+	migrator, err := migrate.New(b.db, migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+	version, err := migrator.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := b.db.Stats()
+	return map[string]any{
+		"database":         "available",
+		"schema_version":   version,
+		"latest_migration": migrator.Latest(),
+		"open_connections": stats.OpenConnections,
+	}, nil
+}
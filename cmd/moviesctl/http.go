@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	"github.com/yanglyu520/movies-golang-web-api/internal/moviesio"
+)
+
+// httpBackend works through the REST API of a running server, so its changes go through the
+// same checks, hooks and caches as any other client's.
+type httpBackend struct {
+	base   *url.URL
+	actor  string
+	client *http.Client
+}
+
+func newHTTPBackend(base, actor string) (*httpBackend, error) {
+	u, err := url.Parse(strings.TrimSuffix(base, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid API URL %q", base)
+	}
+	// Requests are bounded by their context, since imports and exports take as long as they take.
+	return &httpBackend{base: u, actor: actor, client: &http.Client{}}, nil
+}
+
+func (b *httpBackend) Close() error {
+	b.client.CloseIdleConnections()
+	return nil
+}
+
+// apiError is an error response of the API.
+type apiError struct {
+	status  int
+	message any
+}
+
+func (e *apiError) Error() string {
+	switch msg := e.message.(type) {
+	case string:
+		return fmt.Sprintf("%s (%d)", msg, e.status)
+	case map[string]any:
+		errs := make(map[string]string, len(msg))
+		for field, value := range msg {
+			errs[field] = fmt.Sprint(value)
+		}
+		return fmt.Sprintf("invalid input: %s (%d)", formatFieldErrors(errs), e.status)
+	}
+	return fmt.Sprintf("the API responded with %d %s", e.status, http.StatusText(e.status))
+}
+
+// do sends a request to the API and decodes a JSON response into dst, if given. Responses with a
+// status other than 2xx are returned as an *apiError.
+func (b *httpBackend) do(ctx context.Context, method, path string, query url.Values, body io.Reader, header http.Header, dst any) error {
+	res, err := b.send(ctx, method, path, query, body, header)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if dst == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(dst)
+}
+
+// send sends a request to the API and returns the response if it has a 2xx status. The caller
+// must close its body.
+func (b *httpBackend) send(ctx context.Context, method, path string, query url.Values, body io.Reader, header http.Header) (*http.Response, error) {
+	u := b.base.JoinPath(path)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	if b.actor != "" {
+		req.Header.Set("X-Actor", b.actor)
+	}
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	var env struct {
+		Error any `json:"error"`
+	}
+	_ = json.NewDecoder(io.LimitReader(res.Body, 1024*1024)).Decode(&env)
+
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		if strings.HasPrefix(path, "/v1/movies/") {
+			return nil, errNotFound
+		}
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return nil, errConflict
+	}
+	return nil, &apiError{status: res.StatusCode, message: env.Error}
+}
+
+func jsonBody(v any) (io.Reader, http.Header, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bytes.NewReader(js), http.Header{"Content-Type": {"application/json"}}, nil
+}
+
+func moviePath(id int64) string {
+	return "/v1/movies/" + strconv.FormatInt(id, 10)
+}
+
+func (q movieQuery) values() url.Values {
+	values := url.Values{}
+	if q.Title != "" {
+		values.Set("title", q.Title)
+	}
+	if len(q.Genres) > 0 {
+		values.Set("genres", strings.Join(q.Genres, ","))
+	}
+	if q.Fuzzy {
+		values.Set("fuzzy", "true")
+	}
+	return values
+}
+
+func (b *httpBackend) List(ctx context.Context, q movieQuery) ([]*data.Movie, data.Metadata, error) {
+	values := q.values()
+	values.Set("page", strconv.Itoa(q.Page))
+	values.Set("page_size", strconv.Itoa(q.PageSize))
+	values.Set("sort", q.Sort)
+
+	var env struct {
+		Movies   []*data.Movie `json:"movies"`
+		Metadata data.Metadata `json:"metadata"`
+	}
+	err := b.do(ctx, http.MethodGet, "/v1/movies", values, nil, nil, &env)
+	return env.Movies, env.Metadata, err
+}
+
+func (b *httpBackend) Get(ctx context.Context, id int64) (*data.Movie, error) {
+	var env struct {
+		Movie *data.Movie `json:"movie"`
+	}
+	if err := b.do(ctx, http.MethodGet, moviePath(id), nil, nil, nil, &env); err != nil {
+		return nil, err
+	}
+	return env.Movie, nil
+}
+
+func (b *httpBackend) Create(ctx context.Context, movie *data.Movie) error {
+	body, header, err := jsonBody(map[string]any{
+		"title":   movie.Title,
+		"year":    movie.Year,
+		"runtime": movie.Runtime,
+		"genres":  movie.Genres,
+	})
+	if err != nil {
+		return err
+	}
+
+	var env struct {
+		Movie *data.Movie `json:"movie"`
+	}
+	if err = b.do(ctx, http.MethodPost, "/v1/movies", nil, body, header, &env); err != nil {
+		return err
+	}
+	*movie = *env.Movie
+	return nil
+}
+
+func (b *httpBackend) Update(ctx context.Context, id int64, patch moviePatch) (*data.Movie, error) {
+	// The API requires the expected version; without one, the current version is expected.
+	if patch.Version == nil {
+		movie, err := b.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		patch.Version = &movie.Version
+	}
+
+	body, header, err := jsonBody(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	var env struct {
+		Movie *data.Movie `json:"movie"`
+	}
+	if err = b.do(ctx, http.MethodPatch, moviePath(id), nil, body, header, &env); err != nil {
+		return nil, err
+	}
+	return env.Movie, nil
+}
+
+func (b *httpBackend) Delete(ctx context.Context, id int64, version *int32) error {
+	// The API requires an If-Match precondition; without a version, the current one is expected.
+	if version == nil {
+		movie, err := b.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		version = &movie.Version
+	}
+
+	header := http.Header{"If-Match": {fmt.Sprintf(`"%d-%d"`, id, *version)}}
+	return b.do(ctx, http.MethodDelete, moviePath(id), nil, nil, header, nil)
+}
+
+func (b *httpBackend) Import(ctx context.Context, r io.Reader, format string, dryRun bool) (*moviesio.Report, error) {
+	contentType := "application/x-ndjson"
+	if format == "csv" {
+		contentType = "text/csv"
+	}
+
+	values := url.Values{}
+	if dryRun {
+		values.Set("dry_run", "true")
+	}
+
+	var report moviesio.Report
+	err := b.do(ctx, http.MethodPost, "/v1/movies/import", values, r, http.Header{"Content-Type": {contentType}}, &report)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (b *httpBackend) Export(ctx context.Context, w io.Writer, q movieQuery, format string) error {
+	values := q.values()
+	values.Set("format", format)
+
+	res, err := b.send(ctx, http.MethodGet, "/v1/movies/export", values, nil, http.Header{"Accept": {"*/*"}})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+// Seed goes through the import endpoint, the fastest way in through the API.
+func (b *httpBackend) Seed(ctx context.Context, movies []*data.Movie) (int, error) {
+	var buf bytes.Buffer
+	if err := writeNDJSON(&buf, movies); err != nil {
+		return 0, err
+	}
+
+	report, err := b.Import(ctx, &buf, "ndjson", false)
+	if err != nil {
+		return 0, err
+	}
+	if report.Error != "" {
+		return report.Accepted, errors.New(report.Error)
+	}
+	if report.Rejected > 0 {
+		for _, row := range report.Rows {
+			if row.Status == "rejected" {
+				return report.Accepted, fmt.Errorf("movie %q: invalid input: %s", movies[row.Line-1].Title, formatFieldErrors(row.Errors))
+			}
+		}
+	}
+	return report.Accepted, nil
+}
+
+func (b *httpBackend) Health(ctx context.Context) (map[string]any, error) {
+	var env map[string]any
+	if err := b.do(ctx, http.MethodGet, "/v1/healthcheck", nil, nil, nil, &env); err != nil {
+		return nil, err
+	}
+
+	health, ok := env["env"].(map[string]any)
+	if !ok {
+		return nil, errors.New("unexpected healthcheck response")
+	}
+	return health, nil
+}
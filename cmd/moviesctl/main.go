@@ -0,0 +1,159 @@
+// Command moviesctl administers the movie catalog, either directly in the database or through a
+// running API.
+//
+// Usage:
+//
+//	moviesctl [flags] <command> [command flags] [arguments]
+//
+// Run moviesctl -h for the flags and commands.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const usage = `Usage: moviesctl [flags] <command> [command flags] [arguments]
+
+Commands:
+  list                  list movies
+  get ID                show a movie
+  create                create a movie
+  update ID             change a movie
+  delete ID             delete a movie
+  import FILE           import movies from a CSV or NDJSON file (- for stdin)
+  export                export movies as NDJSON or CSV
+  health                show the health of the API or the database
+  seed                  load generated or fixture movies, with COPY in the database
+  generate              write the movies seed would load as NDJSON, without loading them
+  users, permissions    not supported: the API has no user accounts
+
+Run moviesctl <command> -h for the flags of a command.
+
+Flags:
+`
+
+type config struct {
+	dsn     string
+	api     string
+	output  string
+	actor   string
+	outbox  bool
+	timeout time.Duration
+}
+
+// errUsage is returned for invalid command lines, after the usage has been printed.
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	var cfg config
+
+	flag.StringVar(&cfg.dsn, "db-dsn", os.Getenv("MOVIE_DB_DSN"), "movies postgres dsn, used unless -api is set")
+	flag.StringVar(&cfg.api, "api", os.Getenv("MOVIESCTL_API"), "base URL of a running API, such as http://localhost:4000")
+	flag.StringVar(&cfg.output, "o", "table", "output format: table or json")
+	flag.StringVar(&cfg.actor, "actor", os.Getenv("USER"), "actor recorded in the audit log for changes")
+	flag.BoolVar(&cfg.outbox, "outbox", false, "store movie events in the outbox, for databases whose API relays it with -outbox-sink; used unless -api is set")
+	flag.DurationVar(&cfg.timeout, "timeout", 30*time.Second, "timeout of a command, except import and export")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	err := run(cfg, flag.Args(), os.Stdout)
+	switch {
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "moviesctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(cfg config, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		flag.Usage()
+		return errUsage
+	}
+	if cfg.output != "table" && cfg.output != "json" {
+		return fmt.Errorf("invalid output format %q: must be table or json", cfg.output)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(flag.CommandLine.Output(), "moviesctl: unknown command %q\n\n", args[0])
+		flag.Usage()
+		return errUsage
+	}
+	if cmd.unsupported != "" {
+		return errors.New(cmd.unsupported)
+	}
+
+	fs := flag.NewFlagSet("moviesctl "+args[0], flag.ContinueOnError)
+	run := cmd.setup(fs)
+	positional, err := parseInterspersed(fs, args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+
+	var b backend
+	if !cmd.offline {
+		if b, err = newBackend(cfg); err != nil {
+			return err
+		}
+		defer b.Close()
+	}
+
+	ctx := context.Background()
+	if !cmd.streaming {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.timeout)
+		defer cancel()
+	}
+
+	err = run(ctx, b, &printer{w: stdout, json: cfg.output == "json"}, positional)
+	if errors.Is(err, errUsage) {
+		fs.Usage()
+	}
+	return err
+}
+
+// parseInterspersed parses the flags of a command wherever they appear, so that both
+// "update -title X 1" and "update 1 -title X" work, and returns the remaining arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		// Parsing stops at the first argument, or after "--", which ends the flags for good.
+		if parsed := len(args) - fs.NArg(); parsed > 0 && args[parsed-1] == "--" {
+			return append(positional, fs.Args()...), nil
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// newBackend talks to the API when -api is set, and to the database otherwise.
+func newBackend(cfg config) (backend, error) {
+	if cfg.api != "" {
+		return newHTTPBackend(cfg.api, cfg.actor)
+	}
+	if cfg.dsn == "" {
+		return nil, errors.New("either -api or -db-dsn (or $MOVIE_DB_DSN) must be set")
+	}
+	return newDBBackend(cfg.dsn, cfg.actor, cfg.outbox)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	"github.com/yanglyu520/movies-golang-web-api/internal/moviesio"
+)
+
+// printer writes command results as aligned tables, or as indented JSON with -o json.
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+func (p *printer) writeJSON(v any) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(v)
+}
+
+func (p *printer) table() *tabwriter.Writer {
+	return tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+}
+
+func (p *printer) movieList(movies []*data.Movie, metadata data.Metadata) error {
+	if p.json {
+		if movies == nil {
+			movies = []*data.Movie{}
+		}
+		return p.writeJSON(map[string]any{"movies": movies, "metadata": metadata})
+	}
+
+	tw := p.table()
+	fmt.Fprintln(tw, "ID\tTITLE\tYEAR\tRUNTIME\tGENRES\tVERSION")
+	for _, movie := range movies {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%s\t%d\n", movie.ID, movie.Title, movie.Year, movie.Runtime, strings.Join(movie.Genres, ", "), movie.Version)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if metadata.TotalRecords == 0 {
+		_, err := fmt.Fprintln(p.w, "no movies found")
+		return err
+	}
+	_, err := fmt.Fprintf(p.w, "page %d of %d, %d movies\n", metadata.CurrentPage, metadata.LastPage, metadata.TotalRecords)
+	return err
+}
+
+func (p *printer) movie(movie *data.Movie) error {
+	if p.json {
+		return p.writeJSON(map[string]any{"movie": movie})
+	}
+
+	tw := p.table()
+	fmt.Fprintf(tw, "ID:\t%d\n", movie.ID)
+	fmt.Fprintf(tw, "Title:\t%s\n", movie.Title)
+	fmt.Fprintf(tw, "Year:\t%d\n", movie.Year)
+	fmt.Fprintf(tw, "Runtime:\t%d mins\n", movie.Runtime)
+	fmt.Fprintf(tw, "Genres:\t%s\n", strings.Join(movie.Genres, ", "))
+	fmt.Fprintf(tw, "Version:\t%d\n", movie.Version)
+	return tw.Flush()
+}
+
+// message prints text, or value with -o json.
+func (p *printer) message(text string, value any) error {
+	if p.json {
+		return p.writeJSON(value)
+	}
+	_, err := fmt.Fprintln(p.w, text)
+	return err
+}
+
+func (p *printer) importReport(report *moviesio.Report) error {
+	if p.json {
+		return p.writeJSON(report)
+	}
+
+	verb := "imported"
+	if report.DryRun {
+		verb = "would import"
+	}
+	fmt.Fprintf(p.w, "%s %d movies, rejected %d rows\n", verb, report.Accepted, report.Rejected)
+	if report.Rejected == 0 {
+		return nil
+	}
+
+	tw := p.table()
+	fmt.Fprintln(tw, "LINE\tERRORS")
+	for _, row := range report.Rows {
+		if row.Status == "rejected" {
+			fmt.Fprintf(tw, "%d\t%s\n", row.Line, formatFieldErrors(row.Errors))
+		}
+	}
+	return tw.Flush()
+}
+
+// keyValues prints a map as sorted key: value lines, nested maps flattened into dotted keys.
+func (p *printer) keyValues(values map[string]any) error {
+	if p.json {
+		return p.writeJSON(values)
+	}
+
+	flat := map[string]any{}
+	flatten(flat, "", values)
+
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	tw := p.table()
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%s:\t%v\n", key, flat[key])
+	}
+	return tw.Flush()
+}
+
+func flatten(dst map[string]any, prefix string, values map[string]any) {
+	for key, value := range values {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]any); ok {
+			flatten(dst, key, nested)
+			continue
+		}
+		dst[key] = value
+	}
+}
@@ -1,7 +1,21 @@
 module github.com/yanglyu520/movies-golang-web-api
 
-go 1.22.0
+go 1.24.0
 
 require github.com/go-chi/chi/v5 v5.0.11
 
-require github.com/lib/pq v1.10.9 // indirect
+require (
+	github.com/graph-gophers/graphql-go v1.9.0
+	github.com/lib/pq v1.10.9
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260120221211-b8f7ae30c516
+	google.golang.org/grpc v1.80.0
+	google.golang.org/protobuf v1.36.11
+)
+
+require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	golang.org/x/net v0.49.0 // indirect
+	golang.org/x/sys v0.40.0 // indirect
+	golang.org/x/text v0.33.0 // indirect
+)
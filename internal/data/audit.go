@@ -0,0 +1,173 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// Auditor identifies the origin of the changes made through a model, as recorded in the audit
+// log.
+type Auditor struct {
+	Actor     string
+	RequestID string
+	RemoteIP  string
+}
+
+// AuditChange is the value of a single field before and after a change. Before is null for
+// inserted records and After is null for deleted ones.
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditEntry is a single change recorded in the audit log.
+type AuditEntry struct {
+	ID        int64                  `json:"id"`
+	Entity    string                 `json:"entity"`
+	EntityID  int64                  `json:"entity_id"`
+	Action    string                 `json:"action"`
+	Actor     string                 `json:"actor"`
+	RequestID string                 `json:"request_id"`
+	RemoteIP  string                 `json:"remote_ip"`
+	Changes   map[string]AuditChange `json:"changes"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// AuditFilters narrows down the audit log entries returned by AuditModel.GetAll. Zero values
+// match everything.
+type AuditFilters struct {
+	EntityID int64
+	Actor    string
+	From     time.Time
+	To       time.Time
+}
+
+type AuditList struct {
+	Entries  []*AuditEntry `json:"entries"`
+	Metadata Metadata      `json:"metadata"`
+}
+
+// AuditModel wraps the audit_log table.
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// GetAll returns a page of the audit log entries matching af, newest first.
+func (m AuditModel) GetAll(af AuditFilters, filters Filters) ([]*AuditEntry, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), id, entity, entity_id, action, actor, request_id, remote_ip, changes, created_at
+		FROM audit_log
+		WHERE (entity_id = $1 OR $1 = 0)
+		AND (actor = $2 OR $2 = '')
+		AND (created_at >= $3::timestamptz OR $3::timestamptz IS NULL)
+		AND (created_at < $4::timestamptz OR $4::timestamptz IS NULL)
+		ORDER BY id DESC
+		LIMIT $5 OFFSET $6`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{af.EntityID, af.Actor, nullTime(af.From), nullTime(af.To), filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		var (
+			entry   AuditEntry
+			changes []byte
+		)
+		err := rows.Scan(&totalRecords, &entry.ID, &entry.Entity, &entry.EntityID, &entry.Action,
+			&entry.Actor, &entry.RequestID, &entry.RemoteIP, &changes, &entry.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		if err = json.Unmarshal(changes, &entry.Changes); err != nil {
+			return nil, Metadata{}, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// WithAuditor returns a copy of the models whose changes are attributed to a in the audit log.
+func (m Models) WithAuditor(a Auditor) Models {
+	m.Movies = m.Movies.WithAuditor(a)
+	return m
+}
+
+// WithAuditor returns a copy of the model whose changes are attributed to a in the audit log.
+func (m MovieModel) WithAuditor(a Auditor) MovieModel {
+	m.auditor = a
+	return m
+}
+
+// audit records a change to a movie in the audit log. It must run in the transaction making the
+// change, so that the change and its record are committed together. before is nil for an insert
+// and after is nil for a delete.
+func (m MovieModel) audit(ctx context.Context, action string, id int64, before, after *Movie) error {
+	query := `
+		INSERT INTO audit_log (entity, entity_id, action, actor, request_id, remote_ip, changes)
+		VALUES ('movie', $1, $2, $3, $4, $5, $6)`
+
+	changes, err := json.Marshal(movieChanges(before, after))
+	if err != nil {
+		return err
+	}
+
+	args := []any{id, action, m.auditor.Actor, m.auditor.RequestID, m.auditor.RemoteIP, changes}
+
+	_, err = m.conn().ExecContext(ctx, query, args...)
+	return err
+}
+
+// movieChanges returns the fields that differ between before and after, either of which may be
+// nil.
+func movieChanges(before, after *Movie) map[string]AuditChange {
+	beforeFields, afterFields := auditFields(before), auditFields(after)
+
+	changes := map[string]AuditChange{}
+	for _, field := range MovieFieldSafelist {
+		if field == "id" {
+			continue
+		}
+		b, a := beforeFields[field], afterFields[field]
+		if !reflect.DeepEqual(b, a) {
+			changes[field] = AuditChange{Before: b, After: a}
+		}
+	}
+	return changes
+}
+
+func auditFields(movie *Movie) map[string]any {
+	if movie == nil {
+		return nil
+	}
+	genres := movie.Genres
+	if genres == nil {
+		genres = []string{}
+	}
+	return map[string]any{
+		"title":   movie.Title,
+		"year":    movie.Year,
+		"runtime": movie.Runtime,
+		"genres":  genres,
+		"version": movie.Version,
+	}
+}
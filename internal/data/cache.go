@@ -0,0 +1,236 @@
+package data
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MovieCache is a bounded LRU cache of single movies and movie list pages, whose entries expire
+// after a fixed TTL. It is kept consistent by the writes of MovieModel, which invalidate the
+// affected entries once they commit. A nil *MovieCache is valid and caches nothing, which is how
+// the cache is switched off.
+type MovieCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[string]*list.Element
+	lru      *list.List
+	// generation is bumped by every invalidation, so that a read that started before a write
+	// committed cannot store its stale result afterwards.
+	generation uint64
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+// movieListPage is a cached GetAll result.
+type movieListPage struct {
+	movies   []*Movie
+	metadata Metadata
+}
+
+// CacheStats is a snapshot of the cache usage counters.
+type CacheStats struct {
+	Entries  int    `json:"entries"`
+	Capacity int    `json:"capacity"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+}
+
+// NewMovieCache returns a cache holding at most capacity entries for at most ttl each.
+func NewMovieCache(capacity int, ttl time.Duration) *MovieCache {
+	return &MovieCache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Stats returns the current usage counters.
+func (c *MovieCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+
+	return CacheStats{
+		Entries:  entries,
+		Capacity: c.capacity,
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+	}
+}
+
+// get returns the live entry stored under key, along with the generation a caller must pass to
+// put when it stores the result of a lookup that missed.
+func (c *MovieCache) get(key string) (any, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.lru.MoveToFront(el)
+			c.hits.Add(1)
+			return entry.value, c.generation, true
+		}
+		c.removeElement(el)
+	}
+
+	c.misses.Add(1)
+	return nil, c.generation, false
+}
+
+// put stores value under key, unless the cache was invalidated since generation was returned by
+// get.
+func (c *MovieCache) put(key string, value any, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.value, entry.expires = value, expires
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value, expires: expires})
+	for c.lru.Len() > c.capacity {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+func (c *MovieCache) removeElement(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+// invalidate drops the cached movie with the given ID, if any, and every cached list page,
+// since any write may change the contents or the order of any page. Pass an id of 0 to only
+// drop the list pages.
+func (c *MovieCache) invalidate(id int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	if el, ok := c.entries[movieCacheKey(id)]; ok {
+		c.removeElement(el)
+	}
+	for key, el := range c.entries {
+		if strings.HasPrefix(key, "list:") {
+			c.removeElement(el)
+		}
+	}
+}
+
+// getMovie returns a copy of the cached movie with the given ID.
+func (c *MovieCache) getMovie(id int64) (*Movie, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+
+	value, generation, ok := c.get(movieCacheKey(id))
+	if !ok {
+		return nil, generation, false
+	}
+	return value.(*Movie).clone(), generation, true
+}
+
+func (c *MovieCache) putMovie(movie *Movie, generation uint64) {
+	if c == nil {
+		return
+	}
+	c.put(movieCacheKey(movie.ID), movie.clone(), generation)
+}
+
+// getList returns the cached page for key. The movies are shared with other readers and must
+// not be modified.
+func (c *MovieCache) getList(key string) ([]*Movie, Metadata, uint64, bool) {
+	if c == nil {
+		return nil, Metadata{}, 0, false
+	}
+
+	value, generation, ok := c.get(key)
+	if !ok {
+		return nil, Metadata{}, generation, false
+	}
+	page := value.(movieListPage)
+	return page.movies, page.metadata, generation, true
+}
+
+func (c *MovieCache) putList(key string, movies []*Movie, metadata Metadata, generation uint64) {
+	if c == nil {
+		return
+	}
+	c.put(key, movieListPage{movies: movies, metadata: metadata}, generation)
+}
+
+func movieCacheKey(id int64) string {
+	return fmt.Sprintf("movie:%d", id)
+}
+
+// listCacheKey normalizes a GetAll query, so that requests differing only in ways that cannot
+// change the result (title case, genre and field order) share a cache entry.
+func listCacheKey(title string, genres []string, filters Filters) string {
+	genres = append([]string(nil), genres...)
+	sort.Strings(genres)
+	genres = dedupeSorted(genres)
+
+	fields := append([]string(nil), filters.Fields...)
+	sort.Strings(fields)
+	fields = dedupeSorted(fields)
+
+	similarity := ""
+	if filters.Fuzzy {
+		similarity = formatSimilarity(filters.Similarity)
+	}
+
+	return fmt.Sprintf("list:%q|%q|%d|%d|%s|%t|%s|%q",
+		strings.ToLower(title), genres,
+		filters.Page, filters.PageSize, filters.Sort, filters.Fuzzy, similarity, fields)
+}
+
+func dedupeSorted(values []string) []string {
+	out := values[:0]
+	for i, value := range values {
+		if i == 0 || value != values[i-1] {
+			out = append(out, value)
+		}
+	}
+	return out
+}
+
+// clone returns a deep copy of the movie, so that cached movies are never shared with callers
+// that may modify them.
+func (movie *Movie) clone() *Movie {
+	c := *movie
+	if movie.Genres != nil {
+		c.Genres = append([]string(nil), movie.Genres...)
+	}
+	return &c
+}
@@ -0,0 +1,143 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMovieCacheLRUEviction(t *testing.T) {
+	c := NewMovieCache(2, time.Hour)
+
+	_, generation, _ := c.getMovie(1)
+	c.putMovie(&Movie{ID: 1, Title: "Alien"}, generation)
+	c.putMovie(&Movie{ID: 2, Title: "Aliens"}, generation)
+
+	// Reading movie 1 makes movie 2 the least recently used.
+	if _, _, ok := c.getMovie(1); !ok {
+		t.Fatal("movie 1 is not cached")
+	}
+	c.putMovie(&Movie{ID: 3, Title: "Alien 3"}, generation)
+
+	if _, _, ok := c.getMovie(2); ok {
+		t.Error("movie 2 is still cached; want it evicted as least recently used")
+	}
+	for _, id := range []int64{1, 3} {
+		if _, _, ok := c.getMovie(id); !ok {
+			t.Errorf("movie %d was evicted", id)
+		}
+	}
+	if stats := c.Stats(); stats.Entries != 2 || stats.Capacity != 2 {
+		t.Errorf("Stats() = %+v; want 2 entries of capacity 2", stats)
+	}
+}
+
+func TestMovieCacheTTL(t *testing.T) {
+	c := NewMovieCache(10, time.Hour)
+
+	_, generation, _ := c.getMovie(1)
+	c.putMovie(&Movie{ID: 1, Title: "Alien"}, generation)
+	if _, _, ok := c.getMovie(1); !ok {
+		t.Fatal("live movie is not served")
+	}
+
+	c.entries[movieCacheKey(1)].Value.(*cacheEntry).expires = time.Now().Add(-time.Second)
+
+	if _, _, ok := c.getMovie(1); ok {
+		t.Error("expired movie is served")
+	}
+	if stats := c.Stats(); stats.Entries != 0 {
+		t.Errorf("Stats().Entries = %d after expiry; want the expired entry dropped", stats.Entries)
+	}
+}
+
+func TestMovieCacheStalePut(t *testing.T) {
+	c := NewMovieCache(10, time.Hour)
+
+	// A read misses, then a write commits and invalidates before the read stores its result.
+	_, generation, _ := c.getMovie(1)
+	_, _, listGeneration, _ := c.getList("list:a")
+	c.invalidate(1)
+	c.putMovie(&Movie{ID: 1, Title: "Alien", Version: 1}, generation)
+	c.putList("list:a", []*Movie{{ID: 1}}, Metadata{}, listGeneration)
+
+	if _, _, ok := c.getMovie(1); ok {
+		t.Error("movie read before the invalidation was stored after it")
+	}
+	if _, _, _, ok := c.getList("list:a"); ok {
+		t.Error("list page read before the invalidation was stored after it")
+	}
+
+	// A read started after the invalidation is stored.
+	_, generation, _ = c.getMovie(1)
+	c.putMovie(&Movie{ID: 1, Title: "Alien", Version: 2}, generation)
+	movie, _, ok := c.getMovie(1)
+	if !ok || movie.Version != 2 {
+		t.Errorf("getMovie(1) = %+v, %t; want version 2", movie, ok)
+	}
+}
+
+func TestMovieCacheInvalidate(t *testing.T) {
+	c := NewMovieCache(10, time.Hour)
+
+	_, generation, _ := c.getMovie(1)
+	c.putMovie(&Movie{ID: 1}, generation)
+	c.putMovie(&Movie{ID: 2}, generation)
+	c.putList("list:a", nil, Metadata{}, generation)
+	c.putList("list:b", nil, Metadata{}, generation)
+
+	c.invalidate(1)
+
+	if _, _, ok := c.getMovie(1); ok {
+		t.Error("invalidated movie is still cached")
+	}
+	if _, _, ok := c.getMovie(2); !ok {
+		t.Error("movie 2 was dropped by invalidating movie 1")
+	}
+	for _, key := range []string{"list:a", "list:b"} {
+		if _, _, _, ok := c.getList(key); ok {
+			t.Errorf("list page %s survived an invalidation", key)
+		}
+	}
+}
+
+func TestMovieCacheCopies(t *testing.T) {
+	c := NewMovieCache(10, time.Hour)
+
+	movie := &Movie{ID: 1, Genres: []string{"horror"}}
+	_, generation, _ := c.getMovie(1)
+	c.putMovie(movie, generation)
+	movie.Genres[0] = "comedy"
+
+	cached, _, _ := c.getMovie(1)
+	cached.Genres[0] = "drama"
+
+	again, _, _ := c.getMovie(1)
+	if again.Genres[0] != "horror" {
+		t.Errorf("cached genres = %v; want them unaffected by changes to the stored and returned movies", again.Genres)
+	}
+}
+
+func TestMovieCacheNil(t *testing.T) {
+	var c *MovieCache
+	c.putMovie(&Movie{ID: 1}, 0)
+	c.invalidate(1)
+	if _, _, ok := c.getMovie(1); ok {
+		t.Error("nil cache served a movie")
+	}
+	if stats := c.Stats(); stats != (CacheStats{}) {
+		t.Errorf("nil cache Stats() = %+v; want zero", stats)
+	}
+}
+
+func TestListCacheKey(t *testing.T) {
+	a := listCacheKey("Alien", []string{"sci-fi", "horror"}, Filters{Page: 1, PageSize: 20, Sort: "id", Fields: []string{"title", "id"}})
+	b := listCacheKey("alien", []string{"horror", "sci-fi", "horror"}, Filters{Page: 1, PageSize: 20, Sort: "id", Fields: []string{"id", "title"}})
+	if a != b {
+		t.Errorf("equivalent queries have different keys:\n%s\n%s", a, b)
+	}
+
+	c := listCacheKey("alien", []string{"horror", "sci-fi"}, Filters{Page: 2, PageSize: 20, Sort: "id", Fields: []string{"id", "title"}})
+	if a == c {
+		t.Error("different pages share a key")
+	}
+}
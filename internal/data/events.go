@@ -0,0 +1,101 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// movieChangesChannel is the channel the movies_notify_change trigger notifies.
+const movieChangesChannel = "movie_changes"
+
+// movieChangesReset is the op of the notification a bulk load sends in place of its changes.
+const movieChangesReset = "reset"
+
+// MovieChange is a committed change to a movie, as published by the movies_notify_change
+// trigger. Seq numbers changes in the order they were made; Op is insert, update or delete.
+type MovieChange struct {
+	Seq   int64  `json:"seq"`
+	Op    string `json:"op"`
+	Movie Movie  `json:"movie"`
+}
+
+// Event returns the name of the event for the change, such as movie.created.
+func (c *MovieChange) Event() string {
+	return movieChangeEvents[c.Op]
+}
+
+// MovieChangeHandler receives the changes delivered by ListenMovieChanges.
+type MovieChangeHandler interface {
+	// Change is called for every change, in the order they were committed.
+	Change(change *MovieChange)
+	// Reset is called after the connection to the database was re-established, during which
+	// changes may have been missed, and after a bulk load, whose changes are not published.
+	Reset()
+}
+
+// MovieChangeHandlers passes every change and reset on to each of its handlers, in order.
+type MovieChangeHandlers []MovieChangeHandler
+
+func (hs MovieChangeHandlers) Change(change *MovieChange) {
+	for _, h := range hs {
+		h.Change(change)
+	}
+}
+
+func (hs MovieChangeHandlers) Reset() {
+	for _, h := range hs {
+		h.Reset()
+	}
+}
+
+// ListenMovieChanges listens for movie changes on a dedicated connection to the database at dsn
+// and passes them on to h until ctx is done. Errors are reported to errorLog and never stop the
+// listener, which keeps reconnecting.
+func ListenMovieChanges(ctx context.Context, dsn string, h MovieChangeHandler, errorLog func(error)) error {
+	listener := pq.NewListener(dsn, 10*time.Second, time.Minute, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			errorLog(err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(movieChangesChannel); err != nil {
+		return err
+	}
+
+	// The connection is pinged when nothing was received for a while, so that a broken
+	// connection is noticed and re-established.
+	const pingInterval = 90 * time.Second
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case n := <-listener.Notify:
+			if n == nil {
+				h.Reset()
+				continue
+			}
+
+			var change MovieChange
+			if err := json.Unmarshal([]byte(n.Extra), &change); err != nil {
+				errorLog(err)
+				continue
+			}
+			if change.Op == movieChangesReset {
+				h.Reset()
+				continue
+			}
+			h.Change(&change)
+
+		case <-time.After(pingInterval):
+			if err := listener.Ping(); err != nil {
+				errorLog(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// exportFetchSize is the number of rows fetched from the export cursor per round trip.
+const exportFetchSize = 1000
+
+// Export calls fn for every movie matching the title and genres search, in ID order. Rows are
+// read through a server-side cursor exportFetchSize at a time, so memory use does not depend on
+// the size of the table. Pagination and sorting in filters are ignored. The export stops at the
+// first error returned by fn, or when ctx is cancelled.
+func (m MovieModel) Export(ctx context.Context, title string, genres []string, filters Filters, fn func(*Movie) error) error {
+	columns := movieColumns(nil)
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	if filters.Fuzzy {
+		_, err = tx.ExecContext(ctx, `SELECT set_config('pg_trgm.similarity_threshold', $1, true)`,
+			formatSimilarity(filters.Similarity))
+		if err != nil {
+			return err
+		}
+	}
+
+	declare := fmt.Sprintf(`
+		DECLARE movies_export NO SCROLL CURSOR FOR
+		SELECT %s
+		FROM movies
+		WHERE %s
+		ORDER BY id ASC`, strings.Join(columns, ", "), filters.condition())
+
+	_, err = tx.ExecContext(ctx, declare, title, pq.Array(genres))
+	if err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf(`FETCH FORWARD %d FROM movies_export`, exportFetchSize)
+	for {
+		rows, err := tx.QueryContext(ctx, fetch)
+		if err != nil {
+			return err
+		}
+
+		fetched := 0
+		for rows.Next() {
+			fetched++
+
+			var movie Movie
+			if err = rows.Scan(movie.scanDest(columns)...); err != nil {
+				rows.Close()
+				return err
+			}
+			if err = fn(&movie); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		if fetched < exportFetchSize {
+			break
+		}
+	}
+
+	return tx.Commit()
+}
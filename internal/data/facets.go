@@ -0,0 +1,112 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// FacetSafelist holds the facet names that can be requested alongside a movie list.
+var FacetSafelist = []string{"genres", "year", "runtime"}
+
+// runtimeBucketSize is the width, in minutes, of a runtime facet bucket.
+const runtimeBucketSize = 30
+
+// FacetCount is the number of matching movies sharing a facet value.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets maps a facet name to its counts.
+type Facets map[string][]FacetCount
+
+// facetQueries select a (bucket, count) pair per facet value. Genres are counted per genre, years
+// per decade and runtimes per runtimeBucketSize minutes.
+var facetQueries = map[string]string{
+	"genres": `
+		SELECT genre, count(*)
+		FROM movies CROSS JOIN unnest(genres) AS genre
+		WHERE %s
+		GROUP BY genre
+		ORDER BY count(*) DESC, genre ASC`,
+	"year": `
+		SELECT decade, count(*)
+		FROM (SELECT year / 10 * 10 AS decade FROM movies WHERE %s) AS decades
+		GROUP BY decade
+		ORDER BY decade ASC`,
+	"runtime": fmt.Sprintf(`
+		SELECT bucket, count(*)
+		FROM (SELECT runtime / %[1]d * %[1]d AS bucket FROM movies WHERE %%s) AS buckets
+		GROUP BY bucket
+		ORDER BY bucket ASC`, runtimeBucketSize),
+}
+
+// Facets counts the movies matching the title and genres search per facet value, ignoring
+// pagination. names must only contain values from FacetSafelist.
+func (m MovieModel) Facets(title string, genres []string, filters Filters, names []string) (Facets, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	facets := Facets{}
+	err := m.search(ctx, filters, func(q dbtx) error {
+		for _, name := range names {
+			query, ok := facetQueries[name]
+			if !ok {
+				panic("unsafe facet parameter: " + name)
+			}
+
+			counts, err := scanFacet(ctx, q, name, fmt.Sprintf(query, filters.condition()), title, pq.Array(genres))
+			if err != nil {
+				return err
+			}
+			facets[name] = counts
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return facets, nil
+}
+
+func scanFacet(ctx context.Context, q dbtx, name, query string, args ...any) ([]FacetCount, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []FacetCount{}
+	for rows.Next() {
+		var fc FacetCount
+		switch name {
+		case "genres":
+			err = rows.Scan(&fc.Value, &fc.Count)
+		default:
+			var bucket int
+			err = rows.Scan(&bucket, &fc.Count)
+			fc.Value = bucketLabel(name, bucket)
+		}
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, fc)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// bucketLabel formats the lower bound of a numeric bucket, e.g. "1990s" or "90-119".
+func bucketLabel(name string, bucket int) string {
+	if name == "year" {
+		return fmt.Sprintf("%ds", bucket)
+	}
+	return fmt.Sprintf("%d-%d", bucket, bucket+runtimeBucketSize-1)
+}
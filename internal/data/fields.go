@@ -0,0 +1,138 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/yanglyu520/movies-golang-web-api/internal/validator"
+)
+
+// MovieFieldSafelist holds the field names that can be requested with a fields parameter. It is
+// derived from the JSON tags of Movie, so hidden fields such as CreatedAt are never exposed.
+var MovieFieldSafelist = jsonFieldNames(reflect.TypeOf(Movie{}))
+
+func jsonFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// movieColumns returns the columns to select for the requested fields. The id and version columns
+// are always selected because callers rely on them to identify the movie revision. The field
+// names match their column names, and must come from MovieFieldSafelist.
+func movieColumns(fields []string) []string {
+	if len(fields) == 0 {
+		return []string{"id", "created_at", "title", "year", "runtime", "genres", "version"}
+	}
+
+	columns := []string{"id", "version"}
+	for _, field := range fields {
+		if field == "id" || field == "version" {
+			continue
+		}
+		if !validator.PermittedValue(field, MovieFieldSafelist...) {
+			panic("unsafe field parameter: " + field)
+		}
+		columns = append(columns, field)
+	}
+	return columns
+}
+
+// scanDest returns the scan destinations for the given columns.
+func (movie *Movie) scanDest(columns []string) []any {
+	dest := make([]any, len(columns))
+	for i, column := range columns {
+		switch column {
+		case "id":
+			dest[i] = &movie.ID
+		case "created_at":
+			dest[i] = &movie.CreatedAt
+		case "title":
+			dest[i] = &movie.Title
+		case "year":
+			dest[i] = &movie.Year
+		case "runtime":
+			dest[i] = &movie.Runtime
+		case "genres":
+			dest[i] = pq.Array(&movie.Genres)
+		case "version":
+			dest[i] = &movie.Version
+		default:
+			panic("unknown movie column: " + column)
+		}
+	}
+	return dest
+}
+
+// MovieView renders a movie restricted to a set of fields, in the order they were requested. An
+// empty field list renders the whole movie.
+type MovieView struct {
+	movie  *Movie
+	fields []string
+}
+
+// Project restricts the JSON representation of movie to fields.
+func (movie *Movie) Project(fields []string) MovieView {
+	return MovieView{movie: movie, fields: fields}
+}
+
+func (v MovieView) MarshalJSON() ([]byte, error) {
+	if len(v.fields) == 0 {
+		return json.Marshal(v.movie)
+	}
+
+	js, err := json.Marshal(v.movie)
+	if err != nil {
+		return nil, err
+	}
+
+	var all map[string]json.RawMessage
+	if err = json.Unmarshal(js, &all); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, field := range v.fields {
+		value, ok := all[field]
+		if !ok {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(field)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// MarshalJSON renders the movies restricted to l.Fields.
+func (l MovieList) MarshalJSON() ([]byte, error) {
+	// movieList has the same fields as MovieList but none of its methods, which stops
+	// json.Marshal from recursing back into this method.
+	type movieList MovieList
+
+	views := make([]MovieView, len(l.Movies))
+	for i, movie := range l.Movies {
+		views[i] = movie.Project(l.Fields)
+	}
+
+	return json.Marshal(struct {
+		Movies []MovieView `json:"movies"`
+		movieList
+	}{views, movieList(l)})
+}
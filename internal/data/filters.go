@@ -11,8 +11,19 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// Fuzzy switches the title search from full-text matching to pg_trgm similarity matching,
+	// so that misspelled titles still find their movie.
+	Fuzzy bool
+	// Similarity is the minimum pg_trgm similarity (0 to 1) a title needs to match in fuzzy mode.
+	Similarity float64
+	// Fields restricts the selected columns to the named Movie fields. All fields are selected
+	// when it is empty.
+	Fields []string
 }
 
+// DefaultSimilarity mirrors the default pg_trgm.similarity_threshold.
+const DefaultSimilarity = 0.3
+
 // Metadata holds pagination metadata.
 type Metadata struct {
 	CurrentPage  int `json:"current_page,omitempty"`
@@ -20,6 +31,8 @@ type Metadata struct {
 	FirstPage    int `json:"first_page,omitempty"`
 	LastPage     int `json:"last_page,omitempty"`
 	TotalRecords int `json:"total_records,omitempty"`
+	// Suggestions holds the closest matching titles when a title search returned nothing.
+	Suggestions []string `json:"suggestions,omitempty"`
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -42,6 +55,17 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.Check(f.Similarity >= 0 && f.Similarity <= 1, "similarity", "must be between 0 and 1")
+	ValidateFields(v, f.Fields)
+}
+
+// ValidateFields checks that every requested field is in MovieFieldSafelist, and only requested
+// once.
+func ValidateFields(v *validator.Validator, fields []string) {
+	for _, field := range fields {
+		v.Check(validator.PermittedValue(field, MovieFieldSafelist...), "fields", "invalid field value")
+	}
+	v.Check(validator.Unique(fields), "fields", "must not contain duplicate values")
 }
 
 func (f Filters) sortColumn() string {
@@ -60,6 +84,18 @@ func (f Filters) sortDirection() string {
 	return "ASC"
 }
 
+// condition returns the WHERE clause matching a movie against the title ($1) and genres ($2)
+// search parameters. In fuzzy mode the title is matched with the pg_trgm % operator, which is
+// backed by the movies_title_trgm_idx index and uses the similarity threshold of the current
+// transaction.
+func (f Filters) condition() string {
+	titleCondition := "(to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1='')"
+	if f.Fuzzy {
+		titleCondition = "(title % $1 OR $1='')"
+	}
+	return titleCondition + " AND (genres @> $2 OR $2 = '{}')"
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
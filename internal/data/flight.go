@@ -0,0 +1,93 @@
+package data
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup coalesces identical concurrent calls: while a call for a key is in flight, later
+// callers for the same key wait for its result instead of starting their own. A nil
+// *flightGroup runs every call on its own.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done chan struct{}
+	val  any
+	err  error
+
+	// waiters is the number of callers still waiting for the result. The call is cancelled
+	// when the last of them gives up.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// do runs fn once for all concurrent callers passing the same key and returns its result to
+// each of them.
+//
+// fn runs on a context of its own, detached from the caller that started it, so that the caller
+// going away does not fail the call for the others. A caller whose ctx is done stops waiting and
+// gets ctx.Err(); only once every caller has stopped waiting is the context of fn cancelled.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (any, error)) (any, error) {
+	if g == nil {
+		return fn(ctx)
+	}
+
+	g.mu.Lock()
+	c, ok := g.calls[key]
+	if ok {
+		c.waiters++
+		g.mu.Unlock()
+		return g.wait(ctx, key, c)
+	}
+
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	c = &flightCall{done: make(chan struct{}), waiters: 1, cancel: cancel}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	go func() {
+		defer cancel()
+
+		c.val, c.err = fn(runCtx)
+
+		g.mu.Lock()
+		g.forget(key, c)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+
+	return g.wait(ctx, key, c)
+}
+
+func (g *flightGroup) wait(ctx context.Context, key string, c *flightCall) (any, error) {
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// Nobody needs the result any more, and callers arriving from now on must not
+			// join a call that is being cancelled.
+			c.cancel()
+			g.forget(key, c)
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// forget removes c from the calls in flight, unless it was already replaced by a newer call for
+// the same key. g.mu must be held.
+func (g *flightGroup) forget(key string, c *flightCall) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
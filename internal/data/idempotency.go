@@ -0,0 +1,147 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// IdempotencyRecord is the stored outcome of a request sent with an Idempotency-Key header. A
+// record with a zero Status is still being processed.
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+	Status      int
+	Headers     map[string]string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// IdempotencyModel wraps the idempotency_keys table.
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// reserveAttempts bounds how often Reserve retries when the key is released or taken over between
+// its statements.
+const reserveAttempts = 3
+
+// Reserve claims key for a request with the given fingerprint for ttl, and locks it for lease
+// while the request is processed. It returns nil if the key was free (or had expired) and is now
+// reserved, and the existing record otherwise. A key left in progress past its lease, such as by a
+// crashed server, is taken over by a retry of the same request.
+func (m IdempotencyModel) Reserve(key, fingerprint string, ttl, lease time.Duration) (*IdempotencyRecord, error) {
+	// An expired key is taken over as if it had never been used.
+	reserve := `
+		INSERT INTO idempotency_keys (key, fingerprint, expires_at, locked_until)
+		VALUES ($1, $2, NOW() + make_interval(secs => $3), NOW() + make_interval(secs => $4))
+		ON CONFLICT (key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, status = NULL, headers = '{}', body = NULL,
+			created_at = NOW(), expires_at = EXCLUDED.expires_at, locked_until = EXCLUDED.locked_until
+		WHERE idempotency_keys.expires_at <= NOW()
+			OR (idempotency_keys.status IS NULL AND idempotency_keys.locked_until <= NOW()
+				AND idempotency_keys.fingerprint = EXCLUDED.fingerprint)
+		RETURNING key`
+
+	lookup := `
+		SELECT key, fingerprint, status, headers, body, expires_at
+		FROM idempotency_keys
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var (
+		record  IdempotencyRecord
+		status  sql.NullInt32
+		headers []byte
+	)
+	for attempt := 1; ; attempt++ {
+		var reserved string
+		err := m.DB.QueryRowContext(ctx, reserve, key, fingerprint, ttl.Seconds(), lease.Seconds()).Scan(&reserved)
+		switch {
+		case err == nil:
+			return nil, nil
+		case !errors.Is(err, sql.ErrNoRows):
+			return nil, err
+		}
+
+		err = m.DB.QueryRowContext(ctx, lookup, key).Scan(
+			&record.Key,
+			&record.Fingerprint,
+			&status,
+			&headers,
+			&record.Body,
+			&record.ExpiresAt,
+		)
+		if err == nil {
+			break
+		}
+		// The key was released between the two statements, so it can be reserved again.
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if attempt == reserveAttempts {
+			return nil, fmt.Errorf("idempotency key %q was released %d times while being reserved", key, attempt)
+		}
+	}
+
+	record.Status = int(status.Int32)
+	if err := json.Unmarshal(headers, &record.Headers); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// Complete stores the response of the request holding key, so that retries replay it. When the
+// key was taken over after its lease ran out, the first response stored wins.
+func (m IdempotencyModel) Complete(key string, status int, headers map[string]string, body []byte) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status = $2, headers = $3, body = $4
+		WHERE key = $1 AND status IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	js, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, query, key, status, js, body)
+	return err
+}
+
+// Release frees key, so that a request which failed without side effects can be retried.
+func (m IdempotencyModel) Release(key string) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE key = $1 AND status IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
+
+// DeleteExpired removes every expired key and returns how many were removed.
+func (m IdempotencyModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE expires_at <= NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
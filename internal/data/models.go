@@ -8,15 +8,25 @@ import (
 var (
 	// ErrRecordNotFound is returned when a movie record doesn't exist in database.
 	ErrRecordNotFound = errors.New("record not found")
+	// ErrEditConflict is returned when a movie was changed by someone else since it was read.
+	ErrEditConflict = errors.New("edit conflict")
 )
 
 // Models struct is a single convenient container to hold and represent all our database models.
 type Models struct {
-	Movies MovieModel
+	Movies      MovieModel
+	Idempotency IdempotencyModel
+	Audit       AuditModel
+	Webhooks    WebhookModel
+	Outbox      OutboxModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies: MovieModel{DB: db},
+		Movies:      MovieModel{DB: db, flights: newFlightGroup()},
+		Idempotency: IdempotencyModel{DB: db},
+		Audit:       AuditModel{DB: db},
+		Webhooks:    WebhookModel{DB: db},
+		Outbox:      OutboxModel{DB: db},
 	}
 }
@@ -3,16 +3,22 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/lib/pq"
 	"github.com/yanglyu520/movies-golang-web-api/internal/validator"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type MovieList struct {
 	Movies   []*Movie `json:"movies"`
 	Metadata Metadata `json:"metadata"`
+	Facets   Facets   `json:"facets,omitempty"`
+	// Fields restricts the JSON representation of each movie to the named fields.
+	Fields []string `json:"-"`
 }
 
 // MovieModel struct wraps a sql.DB connection pool and allows us to work with Movie struct type
@@ -21,6 +27,24 @@ type MovieModel struct {
 	DB       *sql.DB
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
+	// Titles is an optional in-process index of titles used to answer autocomplete queries. It
+	// is kept up to date by Insert, Update and Delete.
+	Titles *TitleIndex
+	// Cache is an optional read cache for Get and GetAll. It is invalidated by Insert, Update and
+	// Delete, and bypassed by models bound to a transaction.
+	Cache *MovieCache
+	// Outbox enables storing movie events in the outbox. It must only be set when a relay
+	// publishes and purges them, or the outbox grows without bound.
+	Outbox bool
+
+	// flights coalesces identical concurrent GetAll calls.
+	flights *flightGroup
+
+	// auditor is the origin recorded in the audit log for changes made through the model.
+	auditor Auditor
+
+	// tx is set on models returned by Models.WithTx.
+	tx *txState
 }
 type Movie struct {
 	ID        int64    `json:"id"`
@@ -32,52 +56,215 @@ type Movie struct {
 	Version   int32    `json:"version"`
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// suggestionLimit is the number of "did you mean" titles returned when a title search finds nothing.
+const suggestionLimit = 5
+
+// suggestionSimilarity is the similarity threshold used to look up suggestions. It is deliberately
+// lower than DefaultSimilarity so that badly misspelled titles still get a few candidates.
+const suggestionSimilarity = 0.2
+
+// GetAll returns a page of the movies matching title and genres. Identical calls running at the
+// same time share a single database query.
+func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+	cache := m.readCache()
+	key := listCacheKey(title, genres, filters)
+	cached, cachedMetadata, generation, ok := cache.getList(key)
+	if ok {
+		return cached, cachedMetadata, nil
+	}
+
+	// Reads within a transaction may see its uncommitted writes, so they are never shared.
+	flights := m.flights
+	if m.tx != nil {
+		flights = nil
+	}
+
+	page, err := flights.do(ctx, key, func(ctx context.Context) (any, error) {
+		movies, metadata, err := m.getAll(ctx, title, genres, filters)
+		if err != nil {
+			return nil, err
+		}
+
+		cache.putList(key, movies, metadata, generation)
+		return movieListPage{movies: movies, metadata: metadata}, nil
+	})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	result := page.(movieListPage)
+	return result.movies, result.metadata, nil
+}
+
+func (m MovieModel) getAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+	// Only the columns backing the requested fields are read.
+	columns := movieColumns(filters.Fields)
+
 	// Update the SQL query to include the filter conditions.
 	query := fmt.Sprintf(
-		`SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version 
+		`SELECT count(*) OVER(), %s
 				FROM movies
-				WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1='')
-				AND (genres @> $2 OR $2 = '{}')
+				WHERE %s
 				ORDER BY %s %s, id ASC
-				LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+				LIMIT $3 OFFSET $4`, strings.Join(columns, ", "), filters.condition(), filters.sortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
-	// Pass the title and genres as the placeholder parameter values.
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+
+	var (
+		movies       []*Movie
+		totalRecords int
+	)
+	err := m.search(ctx, filters, func(q dbtx) error {
+		var err error
+		movies, totalRecords, err = scanMovieList(ctx, q, columns, query, args...)
+		return err
+	})
 	if err != nil {
 		return nil, Metadata{}, err
 	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	if len(movies) == 0 && title != "" {
+		// count(*) OVER() is also 0 for a page past the last one, so beyond the first page
+		// whether the search matched anything at all is checked separately.
+		matched := false
+		if filters.Page > 1 {
+			matched, err = m.anyMatch(ctx, title, genres, filters)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+		}
+
+		if !matched {
+			metadata.Suggestions, err = m.suggestTitles(ctx, title)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+		}
+	}
+
+	return movies, metadata, nil
+}
+
+// anyMatch reports whether any movie matches title and genres, regardless of the page.
+func (m MovieModel) anyMatch(ctx context.Context, title string, genres []string, filters Filters) (bool, error) {
+	query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM movies WHERE %s)`, filters.condition())
+
+	var exists bool
+	err := m.search(ctx, filters, func(q dbtx) error {
+		return q.QueryRowContext(ctx, query, title, pq.Array(genres)).Scan(&exists)
+	})
+	return exists, err
+}
+
+// readCache returns the cache reads may be served from. Reads made within a transaction may see
+// its uncommitted writes, so they never use the cache.
+func (m MovieModel) readCache() *MovieCache {
+	if m.tx != nil {
+		return nil
+	}
+	return m.Cache
+}
+
+// search runs fn against the database, inside a transaction with the requested similarity
+// threshold when the filters ask for a fuzzy title match.
+func (m MovieModel) search(ctx context.Context, filters Filters, fn func(q dbtx) error) error {
+	if !filters.Fuzzy {
+		return fn(m.DB)
+	}
+	return m.withSimilarity(ctx, filters.Similarity, func(tx *sql.Tx) error {
+		return fn(tx)
+	})
+}
+
+func scanMovieList(ctx context.Context, q dbtx, columns []string, query string, args ...any) ([]*Movie, int, error) {
+	// Pass the title and genres as the placeholder parameter values.
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
 	defer rows.Close()
 
 	totalRecords := 0
 	movies := []*Movie{}
 	for rows.Next() {
 		var movie Movie
-		err := rows.Scan(
-			&totalRecords,
-			&movie.ID,
-			&movie.CreatedAt,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			pq.Array(&movie.Genres),
-			&movie.Version,
-		)
+		err := rows.Scan(append([]any{&totalRecords}, movie.scanDest(columns)...)...)
 		if err != nil {
-			return nil, Metadata{}, err
+			return nil, 0, err
 		}
 		movies = append(movies, &movie)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		return nil, 0, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
-	return movies, metadata, nil
+	return movies, totalRecords, nil
+}
+
+// suggestTitles returns the titles most similar to title, best match first.
+func (m MovieModel) suggestTitles(ctx context.Context, title string) ([]string, error) {
+	query := `
+		SELECT title
+		FROM movies
+		WHERE title % $1
+		ORDER BY similarity(title, $1) DESC, title ASC
+		LIMIT $2`
+
+	suggestions := []string{}
+	err := m.withSimilarity(ctx, suggestionSimilarity, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, title, suggestionLimit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var suggestion string
+			if err := rows.Scan(&suggestion); err != nil {
+				return err
+			}
+			suggestions = append(suggestions, suggestion)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+// withSimilarity runs fn in a read-only transaction with pg_trgm.similarity_threshold set to
+// similarity. The setting is local to the transaction, so it never leaks to other users of the
+// pooled connection.
+func (m MovieModel) withSimilarity(ctx context.Context, similarity float64, fn func(tx *sql.Tx) error) error {
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT set_config('pg_trgm.similarity_threshold', $1, true)`,
+		formatSimilarity(similarity))
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func formatSimilarity(similarity float64) string {
+	return strconv.FormatFloat(similarity, 'f', -1, 64)
 }
 
 func (m MovieModel) Insert(movie *Movie) error {
@@ -96,7 +283,238 @@ func (m MovieModel) Insert(movie *Movie) error {
 	// clear *what values are being user where* in the query
 	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.CreatedAt}
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	// The movie and its audit log entry are stored together.
+	return m.inTx(ctx, func(m MovieModel) error {
+		err := m.conn().QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+		if err != nil {
+			return err
+		}
+
+		if err = m.recordRevision(ctx, movie); err != nil {
+			return err
+		}
+		if err = m.recordChange(ctx, "insert", movie.ID, nil, movie); err != nil {
+			return err
+		}
+
+		m.afterCommit(func() {
+			m.Titles.Put(movie.suggestion())
+			m.Cache.invalidate(0)
+		})
+		return nil
+	})
+}
+
+// InsertBatch inserts all movies in a single transaction, so either all of them are stored or
+// none is. When the model is already bound to a transaction, that transaction is used. The ID,
+// created_at and version of every movie are filled in on success.
+func (m MovieModel) InsertBatch(movies []*Movie) error {
+	query := `
+		INSERT INTO movies (title, year, runtime, genres, created_at) 
+		VALUES ($1, $2, $3, $4, $5) 
+		RETURNING id, created_at, version
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return m.inTx(ctx, func(m MovieModel) error {
+		stmt, err := m.conn().PrepareContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, movie := range movies {
+			args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.CreatedAt}
+
+			err = stmt.QueryRowContext(ctx, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+			if err != nil {
+				return err
+			}
+
+			if err = m.recordRevision(ctx, movie); err != nil {
+				return err
+			}
+			if err = m.recordChange(ctx, "insert", movie.ID, nil, movie); err != nil {
+				return err
+			}
+		}
+
+		m.afterCommit(func() {
+			for _, movie := range movies {
+				m.Titles.Put(movie.suggestion())
+			}
+			m.Cache.invalidate(0)
+		})
+		return nil
+	})
+}
+
+// Get fetches the movie with the given ID. When fields are given, only their columns (plus id and
+// version) are read; fields must come from MovieFieldSafelist.
+func (m MovieModel) Get(id int64, fields ...string) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	// A cached movie has every field, which serves any projection.
+	cache := m.readCache()
+	cached, generation, ok := cache.getMovie(id)
+	if ok {
+		return cached, nil
+	}
+
+	columns := movieColumns(fields)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM movies
+		WHERE id = $1`, strings.Join(columns, ", "))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var movie Movie
+	err := m.conn().QueryRowContext(ctx, query, id).Scan(movie.scanDest(columns)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if len(fields) == 0 {
+		cache.putMovie(&movie, generation)
+	}
+	return &movie, nil
+}
+
+// Update saves the movie and bumps its version. It only succeeds if the version stored in the
+// database still matches movie.Version, otherwise ErrEditConflict is returned.
+func (m MovieModel) Update(movie *Movie) error {
+	// The old row is locked and returned alongside the new version, for the audit log.
+	query := `
+		WITH old AS (
+			SELECT id, title, year, runtime, genres, version
+			FROM movies
+			WHERE id = $5 AND version = $6
+			FOR UPDATE
+		)
+		UPDATE movies
+		SET title = $1, year = $2, runtime = $3, genres = $4, version = movies.version + 1
+		FROM old
+		WHERE movies.id = old.id
+		RETURNING movies.version, old.title, old.year, old.runtime, old.genres`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ID, movie.Version}
+
+	return m.inTx(ctx, func(m MovieModel) error {
+		before := Movie{ID: movie.ID, Version: movie.Version}
+		err := m.conn().QueryRowContext(ctx, query, args...).Scan(&movie.Version,
+			&before.Title, &before.Year, &before.Runtime, pq.Array(&before.Genres))
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		if err = m.recordRevision(ctx, movie); err != nil {
+			return err
+		}
+		if err = m.recordChange(ctx, "update", movie.ID, &before, movie); err != nil {
+			return err
+		}
+
+		m.afterCommit(func() {
+			m.Titles.Put(movie.suggestion())
+			m.Cache.invalidate(movie.ID)
+		})
+		return nil
+	})
+}
+
+func (m MovieModel) Delete(id int64) error {
+	query := `
+		DELETE FROM movies
+		WHERE id = $1
+		RETURNING title, year, runtime, genres, version`
+
+	return m.delete(id, query, ErrRecordNotFound, id)
+}
+
+// DeleteVersion deletes the movie only if it is still at the given version. ErrEditConflict is
+// returned otherwise, including when the movie no longer exists.
+func (m MovieModel) DeleteVersion(id int64, version int32) error {
+	query := `
+		DELETE FROM movies
+		WHERE id = $1 AND version = $2
+		RETURNING title, year, runtime, genres, version`
+
+	return m.delete(id, query, ErrEditConflict, id, version)
+}
+
+// delete runs a DELETE statement for the movie with the given ID, returning errNoRows when it did
+// not delete anything. The statement must return the deleted row, for the audit log.
+func (m MovieModel) delete(id int64, query string, errNoRows error, args ...any) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.inTx(ctx, func(m MovieModel) error {
+		before := Movie{ID: id}
+		err := m.conn().QueryRowContext(ctx, query, args...).Scan(
+			&before.Title, &before.Year, &before.Runtime, pq.Array(&before.Genres), &before.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return errNoRows
+			default:
+				return err
+			}
+		}
+
+		if err = m.recordChange(ctx, "delete", id, &before, nil); err != nil {
+			return err
+		}
+
+		m.afterCommit(func() {
+			m.Titles.Remove(id)
+			m.Cache.invalidate(id)
+		})
+		return nil
+	})
+}
+
+// recordChange records a change to a movie in the audit log, the webhook delivery queue and,
+// when enabled, the outbox. It must run in the transaction making the change. action is insert, update or delete;
+// before is nil for an insert and after is nil for a delete.
+func (m MovieModel) recordChange(ctx context.Context, action string, id int64, before, after *Movie) error {
+	if err := m.audit(ctx, action, id, before, after); err != nil {
+		return err
+	}
+
+	event, movie := movieChangeEvents[action], after
+	if movie == nil {
+		movie = before
+	}
+	if err := m.emit(ctx, event, movie); err != nil {
+		return err
+	}
+	if !m.Outbox {
+		return nil
+	}
+	return m.enqueue(ctx, event, movie)
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// OutboxEvent is a domain event stored in the outbox until it is published.
+type OutboxEvent struct {
+	ID          int64           `json:"id"`
+	AggregateID int64           `json:"aggregate_id"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// enqueue stores a movie event in the outbox. It must run in the transaction that changed the
+// movie, so that an event is stored if and only if its change is committed.
+func (m MovieModel) enqueue(ctx context.Context, event string, movie *Movie) error {
+	query := `
+		INSERT INTO outbox (aggregate_id, event, payload)
+		VALUES ($1, $2, $3)`
+
+	payload, err := json.Marshal(newMovieEventPayload(event, movie))
+	if err != nil {
+		return err
+	}
+
+	_, err = m.conn().ExecContext(ctx, query, movie.ID, event, payload)
+	return err
+}
+
+// OutboxModel wraps the outbox table.
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+// Claim leases up to limit unpublished events that are due, oldest first, so that no other
+// relay publishes them meanwhile. Only the oldest unpublished event of every movie can be
+// claimed, which keeps the events of a movie in order: the next one only becomes available once
+// the previous one is published. An event whose lease runs out before it is marked as published
+// is claimed again, so every event is published at least once.
+func (m OutboxModel) Claim(limit int, lease time.Duration) ([]*OutboxEvent, error) {
+	query := `
+		WITH due AS (
+			SELECT o.id
+			FROM outbox o
+			WHERE o.published_at IS NULL AND o.next_attempt_at <= NOW()
+			AND NOT EXISTS (
+				SELECT 1
+				FROM outbox earlier
+				WHERE earlier.aggregate_id = o.aggregate_id
+				AND earlier.published_at IS NULL
+				AND earlier.id < o.id
+			)
+			ORDER BY o.id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE outbox
+		SET attempts = outbox.attempts + 1, next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM due
+		WHERE outbox.id = due.id
+		RETURNING outbox.id, outbox.aggregate_id, outbox.event, outbox.payload, outbox.attempts, outbox.created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*OutboxEvent{}
+	for rows.Next() {
+		var (
+			ev      OutboxEvent
+			payload []byte
+		)
+		err := rows.Scan(&ev.ID, &ev.AggregateID, &ev.Event, &payload, &ev.Attempts, &ev.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		ev.Payload = payload
+		events = append(events, &ev)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// MarkPublished records that the event was published.
+func (m OutboxModel) MarkPublished(id int64) error {
+	query := `
+		UPDATE outbox
+		SET published_at = NOW(), last_error = ''
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// MarkFailed records a failed attempt to publish the event, which is retried at nextAttempt.
+func (m OutboxModel) MarkFailed(id int64, message string, nextAttempt time.Time) error {
+	query := `
+		UPDATE outbox
+		SET last_error = $2, next_attempt_at = $3
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, message, nextAttempt)
+	return err
+}
+
+// DeletePublished removes the events published before the given time and returns how many were
+// removed.
+func (m OutboxModel) DeletePublished(before time.Time) (int64, error) {
+	query := `
+		DELETE FROM outbox
+		WHERE published_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
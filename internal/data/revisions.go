@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieRevision is a movie as it was at one of its versions.
+type MovieRevision struct {
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	Title     string    `json:"title"`
+	Year      int32     `json:"year,omitempty"`
+	Runtime   int32     `json:"runtime,omitempty"`
+	Genres    []string  `json:"genres,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RevisionList struct {
+	Revisions []*MovieRevision `json:"revisions"`
+	Metadata  Metadata         `json:"metadata"`
+}
+
+// Movie returns the movie as of the revision.
+func (rev *MovieRevision) Movie() *Movie {
+	return &Movie{
+		ID:      rev.MovieID,
+		Title:   rev.Title,
+		Year:    rev.Year,
+		Runtime: rev.Runtime,
+		Genres:  rev.Genres,
+		Version: rev.Version,
+	}
+}
+
+// recordRevision stores the current state of movie as a revision. It must run in the transaction
+// that changed the movie.
+func (m MovieModel) recordRevision(ctx context.Context, movie *Movie) error {
+	query := `
+		INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	args := []any{movie.ID, movie.Version, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+
+	_, err := m.conn().ExecContext(ctx, query, args...)
+	return err
+}
+
+// Revisions returns a page of the revisions of the movie with the given ID, newest first.
+func (m MovieModel) Revisions(id int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), movie_id, version, title, year, runtime, genres, created_at
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY version DESC
+		LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.conn().QueryContext(ctx, query, id, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+	for rows.Next() {
+		var rev MovieRevision
+		err := rows.Scan(&totalRecords, &rev.MovieID, &rev.Version, &rev.Title, &rev.Year, &rev.Runtime,
+			pq.Array(&rev.Genres), &rev.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, &rev)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return revisions, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// GetRevision fetches the given version of the movie with the given ID.
+func (m MovieModel) GetRevision(id int64, version int32) (*MovieRevision, error) {
+	if id < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT movie_id, version, title, year, runtime, genres, created_at
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rev MovieRevision
+	err := m.conn().QueryRowContext(ctx, query, id, version).Scan(&rev.MovieID, &rev.Version, &rev.Title,
+		&rev.Year, &rev.Runtime, pq.Array(&rev.Genres), &rev.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &rev, nil
+}
+
+// Revert restores the content of the given revision onto movie, which must be at its current
+// version, and saves it as a new version. It returns ErrRecordNotFound when the revision does
+// not exist and ErrEditConflict when movie is not at its current version any more.
+func (m MovieModel) Revert(movie *Movie, version int32) error {
+	rev, err := m.GetRevision(movie.ID, version)
+	if err != nil {
+		return err
+	}
+
+	movie.Title = rev.Title
+	movie.Year = rev.Year
+	movie.Runtime = rev.Runtime
+	movie.Genres = rev.Genres
+
+	return m.Update(movie)
+}
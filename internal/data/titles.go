@@ -0,0 +1,284 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TitleSuggestion is a single autocomplete result.
+type TitleSuggestion struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int32  `json:"year"`
+}
+
+func (movie *Movie) suggestion() TitleSuggestion {
+	return TitleSuggestion{ID: movie.ID, Title: movie.Title, Year: movie.Year}
+}
+
+// TitleIndex is a case-insensitive prefix tree of movie titles. A nil *TitleIndex is valid and
+// ignores all updates, which is how the autocomplete cache is switched off. Besides the writes
+// of its own MovieModel, it follows the changes of other processes when fed by TitleIndexFeed.
+type TitleIndex struct {
+	mu    sync.RWMutex
+	root  *trieNode
+	byID  map[int64]TitleSuggestion
+	ready bool
+}
+
+type trieNode struct {
+	children map[rune]*trieNode
+	// movies holds every movie whose lower cased title ends at this node, ordered by ID.
+	movies []TitleSuggestion
+}
+
+func NewTitleIndex() *TitleIndex {
+	return &TitleIndex{
+		root: &trieNode{},
+		byID: make(map[int64]TitleSuggestion),
+	}
+}
+
+// Put adds the movie to the index, replacing any previous entry with the same ID.
+func (t *TitleIndex) Put(s TitleSuggestion) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.remove(s.ID)
+
+	node := t.root
+	for _, r := range strings.ToLower(s.Title) {
+		if node.children == nil {
+			node.children = make(map[rune]*trieNode)
+		}
+		child, ok := node.children[r]
+		if !ok {
+			child = &trieNode{}
+			node.children[r] = child
+		}
+		node = child
+	}
+	i, _ := slices.BinarySearchFunc(node.movies, s.ID, func(s TitleSuggestion, id int64) int {
+		return cmp.Compare(s.ID, id)
+	})
+	node.movies = slices.Insert(node.movies, i, s)
+	t.byID[s.ID] = s
+}
+
+// Remove drops the movie with the given ID from the index.
+func (t *TitleIndex) Remove(id int64) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.remove(id)
+}
+
+func (t *TitleIndex) remove(id int64) {
+	s, ok := t.byID[id]
+	if !ok {
+		return
+	}
+	delete(t.byID, id)
+
+	title := []rune(strings.ToLower(s.Title))
+	path := make([]*trieNode, 0, len(title)+1)
+	node := t.root
+	path = append(path, node)
+	for _, r := range title {
+		node = node.children[r]
+		if node == nil {
+			return
+		}
+		path = append(path, node)
+	}
+	node.movies = slices.DeleteFunc(node.movies, func(s TitleSuggestion) bool { return s.ID == id })
+
+	// Nodes left without movies or children are pruned, so that retitled and deleted movies do
+	// not leave their old titles behind.
+	for i := len(path) - 1; i > 0; i-- {
+		if len(path[i].movies) > 0 || len(path[i].children) > 0 {
+			break
+		}
+		delete(path[i-1].children, title[i-1])
+	}
+}
+
+// Search returns up to limit movies whose title starts with prefix, ordered by title and then ID.
+func (t *TitleIndex) Search(prefix string, limit int) []TitleSuggestion {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	suggestions := []TitleSuggestion{}
+
+	node := t.root
+	for _, r := range strings.ToLower(prefix) {
+		node = node.children[r]
+		if node == nil {
+			return suggestions
+		}
+	}
+
+	node.collect(&suggestions, limit)
+	return suggestions
+}
+
+// collect walks the subtree in lexical order, appending movies until limit is reached.
+func (n *trieNode) collect(dst *[]TitleSuggestion, limit int) {
+	for _, s := range n.movies {
+		if len(*dst) >= limit {
+			return
+		}
+		*dst = append(*dst, s)
+	}
+
+	keys := make([]rune, 0, len(n.children))
+	for r := range n.children {
+		keys = append(keys, r)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	for _, r := range keys {
+		if len(*dst) >= limit {
+			return
+		}
+		n.children[r].collect(dst, limit)
+	}
+}
+
+// Ready reports whether the index has been warmed and can answer queries on its own.
+func (t *TitleIndex) Ready() bool {
+	if t == nil {
+		return false
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.ready
+}
+
+// WarmTitleIndex loads every title into m.Titles, replacing what it held. Until it has completed
+// once, Autocomplete falls back to the database.
+func (m MovieModel) WarmTitleIndex() error {
+	if m.Titles == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT id, title, year FROM movies`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// The titles are loaded into a fresh index, which then takes the place of the current one.
+	fresh := NewTitleIndex()
+	for rows.Next() {
+		var s TitleSuggestion
+		if err := rows.Scan(&s.ID, &s.Title, &s.Year); err != nil {
+			return err
+		}
+		fresh.Put(s)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	m.Titles.mu.Lock()
+	m.Titles.root, m.Titles.byID = fresh.root, fresh.byID
+	m.Titles.ready = true
+	m.Titles.mu.Unlock()
+
+	return nil
+}
+
+// TitleIndexFeed returns a MovieChangeHandler that applies the changes delivered by
+// ListenMovieChanges to m.Titles, so that the index follows the writes of other API instances
+// and of moviesctl. After a reconnection the index is warmed again in the background; errors are
+// reported to errorLog.
+func (m MovieModel) TitleIndexFeed(errorLog func(error)) MovieChangeHandler {
+	return titleIndexFeed{m: m, errorLog: errorLog}
+}
+
+type titleIndexFeed struct {
+	m        MovieModel
+	errorLog func(error)
+}
+
+func (f titleIndexFeed) Change(change *MovieChange) {
+	if change.Op == "delete" {
+		f.m.Titles.Remove(change.Movie.ID)
+		return
+	}
+	f.m.Titles.Put(change.Movie.suggestion())
+}
+
+// Reset warms the index again, as changes may have been missed. It runs in the background so that
+// the listener is not held up; a change arriving meanwhile may be undone by the reload until the
+// movie changes again.
+func (f titleIndexFeed) Reset() {
+	go func() {
+		if err := f.m.WarmTitleIndex(); err != nil {
+			f.errorLog(err)
+		}
+	}()
+}
+
+// Autocomplete returns up to limit movies whose title starts with prefix, ignoring case.
+func (m MovieModel) Autocomplete(prefix string, limit int) ([]TitleSuggestion, error) {
+	if m.Titles.Ready() {
+		return m.Titles.Search(prefix, limit), nil
+	}
+
+	// The pattern is matched against movies_title_prefix_idx, which indexes lower(title) with
+	// text_pattern_ops so that left-anchored LIKE patterns can use it.
+	query := `
+		SELECT id, title, year
+		FROM movies
+		WHERE lower(title) LIKE $1
+		ORDER BY lower(title) COLLATE "C" ASC, id ASC
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, escapeLike(strings.ToLower(prefix))+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []TitleSuggestion{}
+	for rows.Next() {
+		var s TitleSuggestion
+		if err := rows.Scan(&s.ID, &s.Title, &s.Year); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s so it is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
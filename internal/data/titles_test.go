@@ -0,0 +1,115 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestTitleIndex(suggestions ...TitleSuggestion) *TitleIndex {
+	t := NewTitleIndex()
+	for _, s := range suggestions {
+		t.Put(s)
+	}
+	return t
+}
+
+func suggestionIDs(suggestions []TitleSuggestion) []int64 {
+	ids := make([]int64, len(suggestions))
+	for i, s := range suggestions {
+		ids[i] = s.ID
+	}
+	return ids
+}
+
+func TestTitleIndexSearch(t *testing.T) {
+	index := newTestTitleIndex(
+		TitleSuggestion{ID: 5, Title: "The Godfather Part II", Year: 1974},
+		TitleSuggestion{ID: 3, Title: "The Godfather", Year: 1972},
+		TitleSuggestion{ID: 9, Title: "the godfather", Year: 2030},
+		TitleSuggestion{ID: 1, Title: "The Good, the Bad and the Ugly", Year: 1966},
+		TitleSuggestion{ID: 7, Title: "Godzilla", Year: 1954},
+		TitleSuggestion{ID: 2, Title: "Amélie", Year: 2001},
+	)
+
+	tests := []struct {
+		name   string
+		prefix string
+		limit  int
+		want   []int64
+	}{
+		{"title then ID order", "the go", 10, []int64{3, 9, 5, 1}},
+		{"limit", "the go", 2, []int64{3, 9}},
+		{"limit inside a node", "the godfather", 1, []int64{3}},
+		{"case folding", "THE GODFATHER P", 10, []int64{5}},
+		{"non-ASCII case folding", "AMÉ", 10, []int64{2}},
+		{"exact title", "godzilla", 10, []int64{7}},
+		{"empty prefix", "", 10, []int64{2, 7, 3, 9, 5, 1}},
+		{"no match", "alien", 10, []int64{}},
+		{"longer than any title", "godzillas", 10, []int64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := suggestionIDs(index.Search(tt.prefix, tt.limit))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search(%q, %d) = %v; want %v", tt.prefix, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTitleIndexPutReplaces(t *testing.T) {
+	index := newTestTitleIndex(TitleSuggestion{ID: 1, Title: "Alien", Year: 1979})
+	index.Put(TitleSuggestion{ID: 1, Title: "Aliens", Year: 1986})
+
+	got := index.Search("alien", 10)
+	want := []TitleSuggestion{{ID: 1, Title: "Aliens", Year: 1986}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Search after retitling = %v; want %v", got, want)
+	}
+}
+
+func TestTitleIndexRemovePrunes(t *testing.T) {
+	index := newTestTitleIndex(
+		TitleSuggestion{ID: 1, Title: "Alien"},
+		TitleSuggestion{ID: 2, Title: "Ali"},
+		TitleSuggestion{ID: 3, Title: "Alien"},
+		TitleSuggestion{ID: 4, Title: "Brazil"},
+	)
+
+	// A node still holding another movie is kept.
+	index.Remove(1)
+	if got := suggestionIDs(index.Search("alien", 10)); !reflect.DeepEqual(got, []int64{3}) {
+		t.Fatalf("Search(alien) after removing 1 = %v; want [3]", got)
+	}
+
+	// Removing the last movie of "alien" prunes "e" and "n", but keeps "ali", which holds a movie.
+	index.Remove(3)
+	ali := index.root.children['a'].children['l'].children['i']
+	if len(ali.children) != 0 {
+		t.Errorf(`node "ali" has %d children after removing "alien"; want 0`, len(ali.children))
+	}
+
+	// Removing the only movie of a branch prunes it up to the root.
+	index.Remove(4)
+	if _, ok := index.root.children['b']; ok {
+		t.Error(`node "b" is left after removing "brazil"`)
+	}
+
+	index.Remove(2)
+	if len(index.root.children) != 0 || len(index.byID) != 0 {
+		t.Errorf("index holds %d root children and %d movies after removing every movie; want none", len(index.root.children), len(index.byID))
+	}
+
+	// Removing an unknown ID is a no-op.
+	index.Remove(42)
+}
+
+func TestTitleIndexNil(t *testing.T) {
+	var index *TitleIndex
+	index.Put(TitleSuggestion{ID: 1, Title: "Alien"})
+	index.Remove(1)
+	if index.Ready() {
+		t.Error("nil index is ready")
+	}
+}
@@ -0,0 +1,121 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// dbtx is implemented by both *sql.DB and *sql.Tx, so that model methods run the same queries
+// whether or not they are part of a transaction.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// txState is a transaction shared by a set of models, together with the side effects on
+// in-process state (such as the title index) that must wait until it commits.
+type txState struct {
+	tx          *sql.Tx
+	afterCommit []func()
+	savepoints  int
+}
+
+// WithTx runs fn with a copy of the models bound to a single database transaction, which is
+// committed when fn returns nil and rolled back otherwise. Calling WithTx on models that are
+// already bound to a transaction runs fn in that transaction.
+func (m Models) WithTx(ctx context.Context, fn func(tx Models) error) error {
+	if m.Movies.tx != nil {
+		return fn(m)
+	}
+
+	return withTx(ctx, m.Movies.DB, func(state *txState) error {
+		tx := m
+		tx.Movies.tx = state
+		return fn(tx)
+	})
+}
+
+// Savepoint runs fn within a savepoint of the transaction the models are bound to. If fn fails,
+// only the changes made by fn are rolled back and the transaction stays usable.
+func (m Models) Savepoint(ctx context.Context, fn func() error) error {
+	state := m.Movies.tx
+	if state == nil {
+		panic("data: Savepoint called outside of a transaction")
+	}
+
+	state.savepoints++
+	name := fmt.Sprintf("sp_%d", state.savepoints)
+	hooks := len(state.afterCommit)
+
+	if _, err := state.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+
+	if err := fn(); err != nil {
+		if _, rbErr := state.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rbErr != nil {
+			return rbErr
+		}
+		// Side effects registered by the rolled back work must never run.
+		state.afterCommit = state.afterCommit[:hooks]
+		return err
+	}
+
+	_, err := state.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+func withTx(ctx context.Context, db *sql.DB, fn func(state *txState) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	state := &txState{tx: tx}
+	if err = fn(state); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, hook := range state.afterCommit {
+		hook()
+	}
+	return nil
+}
+
+// conn returns the transaction the model is bound to, or its connection pool.
+func (m MovieModel) conn() dbtx {
+	if m.tx != nil {
+		return m.tx.tx
+	}
+	return m.DB
+}
+
+// inTx runs fn with the model bound to a transaction, reusing the current one if there is one.
+func (m MovieModel) inTx(ctx context.Context, fn func(m MovieModel) error) error {
+	if m.tx != nil {
+		return fn(m)
+	}
+
+	return withTx(ctx, m.DB, func(state *txState) error {
+		m.tx = state
+		return fn(m)
+	})
+}
+
+// afterCommit runs hook once the current transaction commits, or straight away when the model is
+// not bound to a transaction.
+func (m MovieModel) afterCommit(hook func()) {
+	if m.tx != nil {
+		m.tx.afterCommit = append(m.tx.afterCommit, hook)
+		return
+	}
+	hook()
+}
@@ -0,0 +1,452 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/yanglyu520/movies-golang-web-api/internal/validator"
+)
+
+// Movie events delivered to webhook subscriptions.
+const (
+	EventMovieCreated = "movie.created"
+	EventMovieUpdated = "movie.updated"
+	EventMovieDeleted = "movie.deleted"
+)
+
+// movieChangeEvents maps the actions recorded in the audit log to their event.
+var movieChangeEvents = map[string]string{
+	"insert": EventMovieCreated,
+	"update": EventMovieUpdated,
+	"delete": EventMovieDeleted,
+}
+
+// WebhookEvents lists every event a subscription can ask for.
+var WebhookEvents = []string{EventMovieCreated, EventMovieUpdated, EventMovieDeleted}
+
+// Webhook delivery states. A pending delivery is retried until it succeeds or runs out of
+// attempts, at which point it is dead.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// WebhookSubscription is an endpoint that is sent the movie events it subscribed to. An empty
+// Events list subscribes to every event.
+type WebhookSubscription struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	Version   int32     `json:"version"`
+}
+
+// WebhookDelivery is a single event queued for, or delivered to, a subscription.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int64           `json:"subscription_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+
+	// URL and Secret are those of the subscription, filled in for deliveries claimed by a
+	// worker.
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+type DeliveryList struct {
+	Deliveries []*WebhookDelivery `json:"deliveries"`
+	Metadata   Metadata           `json:"metadata"`
+}
+
+// movieEventPayload is the body of a movie event, as posted to webhooks and published from the
+// outbox.
+type movieEventPayload struct {
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Movie      *Movie    `json:"movie"`
+}
+
+func newMovieEventPayload(event string, movie *Movie) movieEventPayload {
+	return movieEventPayload{Event: event, OccurredAt: time.Now().UTC(), Movie: movie}
+}
+
+// internalHosts are host names that always lead to the server itself or its cloud provider,
+// matched exactly or as a parent domain.
+var internalHosts = []string{"localhost", "metadata", "metadata.google.internal", "instance-data"}
+
+// PublicWebhookAddr reports whether webhooks may be delivered to addr. Loopback, private,
+// link-local, shared, unspecified and multicast addresses are refused, so that subscriptions
+// cannot reach the internal network or cloud metadata endpoints such as 169.254.169.254.
+func PublicWebhookAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	switch {
+	case !addr.IsValid(), addr.IsUnspecified(), addr.IsLoopback(), addr.IsPrivate(),
+		addr.IsLinkLocalUnicast(), addr.IsLinkLocalMulticast(), addr.IsInterfaceLocalMulticast(),
+		addr.IsMulticast():
+		return false
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// reservedPrefixes are the non-public ranges netip.Addr has no method for.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// publicWebhookHost reports whether host, a name or an IP address, may be subscribed to. Names
+// are only checked against internalHosts here; the addresses they resolve to are checked when
+// connecting.
+func publicWebhookHost(host string) bool {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return PublicWebhookAddr(addr)
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, internal := range internalHosts {
+		if host == internal || strings.HasSuffix(host, "."+internal) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateWebhookSubscription checks sub. Unless allowPrivate is true, as it may be for local
+// development, its URL must point to a public host.
+func ValidateWebhookSubscription(v *validator.Validator, sub *WebhookSubscription, allowPrivate bool) {
+	u, err := url.Parse(sub.URL)
+	v.Check(sub.URL != "", "url", "must be provided")
+	v.Check(len(sub.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+	if err == nil && u.Host != "" && !allowPrivate {
+		v.Check(publicWebhookHost(u.Hostname()), "url", "must not point to a loopback, private or link-local host")
+	}
+
+	v.Check(len(sub.Secret) >= 16, "secret", "must be at least 16 bytes long")
+	v.Check(len(sub.Secret) <= 200, "secret", "must not be more than 200 bytes long")
+
+	for _, event := range sub.Events {
+		v.Check(validator.PermittedValue(event, WebhookEvents...), "events", "invalid event value")
+	}
+	v.Check(validator.Unique(sub.Events), "events", "must not contain duplicate values")
+}
+
+// emit queues event for every active subscription to it. It must run in the transaction that
+// changed the movie, so that events are only delivered for committed changes.
+func (m MovieModel) emit(ctx context.Context, event string, movie *Movie) error {
+	query := `
+		INSERT INTO webhook_deliveries (subscription_id, event, payload)
+		SELECT id, $1, $2
+		FROM webhook_subscriptions
+		WHERE active AND (events = '{}' OR $1 = ANY(events))`
+
+	payload, err := json.Marshal(newMovieEventPayload(event, movie))
+	if err != nil {
+		return err
+	}
+
+	_, err = m.conn().ExecContext(ctx, query, event, payload)
+	return err
+}
+
+// WebhookModel wraps the webhook_subscriptions and webhook_deliveries tables.
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+const webhookColumns = "id, url, secret, events, active, created_at, version"
+
+func (sub *WebhookSubscription) scanDest() []any {
+	return []any{&sub.ID, &sub.URL, &sub.Secret, pq.Array(&sub.Events), &sub.Active, &sub.CreatedAt, &sub.Version}
+}
+
+func (m WebhookModel) Insert(sub *WebhookSubscription) error {
+	query := `
+		INSERT INTO webhook_subscriptions (url, secret, events, active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{sub.URL, sub.Secret, pq.Array(sub.Events), sub.Active}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&sub.ID, &sub.CreatedAt, &sub.Version)
+}
+
+func (m WebhookModel) Get(id int64) (*WebhookSubscription, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + webhookColumns + `
+		FROM webhook_subscriptions
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var sub WebhookSubscription
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(sub.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &sub, nil
+}
+
+func (m WebhookModel) GetAll() ([]*WebhookSubscription, error) {
+	query := `
+		SELECT ` + webhookColumns + `
+		FROM webhook_subscriptions
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []*WebhookSubscription{}
+	for rows.Next() {
+		var sub WebhookSubscription
+		if err := rows.Scan(sub.scanDest()...); err != nil {
+			return nil, err
+		}
+		subs = append(subs, &sub)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+// Update saves the subscription and bumps its version. It only succeeds if the version stored in
+// the database still matches sub.Version, otherwise ErrEditConflict is returned.
+func (m WebhookModel) Update(sub *WebhookSubscription) error {
+	query := `
+		UPDATE webhook_subscriptions
+		SET url = $1, secret = $2, events = $3, active = $4, version = version + 1
+		WHERE id = $5 AND version = $6
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{sub.URL, sub.Secret, pq.Array(sub.Events), sub.Active, sub.ID, sub.Version}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&sub.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the subscription together with its delivery log.
+func (m WebhookModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM webhook_subscriptions
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+const deliveryColumns = `d.id, d.subscription_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at,
+	d.last_status_code, d.last_error, d.created_at, d.delivered_at`
+
+// scan reads a row selecting deliveryColumns, followed by the columns scanned into extra.
+func (d *WebhookDelivery) scan(row interface{ Scan(...any) error }, extra ...any) error {
+	var (
+		payload     []byte
+		statusCode  sql.NullInt32
+		deliveredAt sql.NullTime
+	)
+	dest := append([]any{&d.ID, &d.SubscriptionID, &d.Event, &payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &statusCode, &d.LastError, &d.CreatedAt, &deliveredAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+
+	d.Payload = payload
+	d.LastStatusCode = int(statusCode.Int32)
+	if deliveredAt.Valid {
+		d.DeliveredAt = &deliveredAt.Time
+	}
+	return nil
+}
+
+// Deliveries returns a page of the delivery log of a subscription, newest first, optionally
+// restricted to deliveries in the given status.
+func (m WebhookModel) Deliveries(subscriptionID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := `
+		SELECT ` + deliveryColumns + `, count(*) OVER()
+		FROM webhook_deliveries d
+		WHERE d.subscription_id = $1 AND (d.status = $2 OR $2 = '')
+		ORDER BY d.id DESC
+		LIMIT $3 OFFSET $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, subscriptionID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := d.scan(rows, &totalRecords); err != nil {
+			return nil, Metadata{}, err
+		}
+		deliveries = append(deliveries, &d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return deliveries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// ClaimDue picks up to limit pending deliveries whose next attempt is due and leases them for
+// lease, so that no other worker attempts them meanwhile. The URL and secret of the subscription
+// are filled in.
+func (m WebhookModel) ClaimDue(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+		WITH due AS (
+			SELECT id
+			FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at, id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM due, webhook_subscriptions s
+		WHERE d.id = due.id AND s.id = d.subscription_id
+		RETURNING ` + deliveryColumns + `, s.url, s.secret`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := d.scan(rows, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// MarkDelivered records a successful delivery attempt.
+func (m WebhookModel) MarkDelivered(id int64, statusCode int) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'delivered', attempts = attempts + 1, last_status_code = $2, last_error = '',
+			delivered_at = NOW()
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, statusCode)
+	return err
+}
+
+// MarkFailed records a failed delivery attempt. The delivery is retried at nextAttempt, or moved
+// to the dead state when nextAttempt is the zero time. statusCode is 0 when no response was
+// received.
+func (m WebhookModel) MarkFailed(id int64, statusCode int, message string, nextAttempt time.Time) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = attempts + 1, last_status_code = NULLIF($3, 0), last_error = $4,
+			next_attempt_at = COALESCE($5, next_attempt_at)
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	status := DeliveryPending
+	if nextAttempt.IsZero() {
+		status = DeliveryDead
+	}
+
+	_, err := m.DB.ExecContext(ctx, query, id, status, statusCode, message, nullTime(nextAttempt))
+	return err
+}
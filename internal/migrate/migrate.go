@@ -0,0 +1,474 @@
+// Package migrate applies the SQL migrations of the database schema. Applied versions are
+// recorded in the schema_migrations table together with a checksum of their up migration, so
+// that a migration edited after it was applied is noticed, and a PostgreSQL advisory lock keeps
+// concurrent runs, such as several instances starting with -auto-migrate, from interfering.
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockKey identifies the advisory lock held while migrating.
+const lockKey int64 = 0x6d6f766965730001
+
+var (
+	// ErrChecksumMismatch is returned when an applied migration no longer matches its file.
+	ErrChecksumMismatch = errors.New("migrate: applied migration was modified")
+	// ErrUnknownVersion is returned when a version to migrate to or from has no migration file.
+	ErrUnknownVersion = errors.New("migrate: unknown migration version")
+)
+
+var fileRX = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a single schema change.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// Checksum is the hex encoded SHA-256 of the up migration, the part that was applied.
+	Checksum string
+}
+
+// Status describes a migration known to the binary, the database or both.
+type Status struct {
+	Version int64
+	Name    string
+	// AppliedAt is zero when the migration was not applied.
+	AppliedAt time.Time
+	// Modified reports that the migration was changed since it was applied.
+	Modified bool
+	// Unknown reports that the database has the migration, but the binary has no file for it.
+	Unknown bool
+}
+
+// Load reads the migrations in the root of fsys, ordered by version. Every version needs an up
+// migration; a missing down migration only fails when it is needed.
+func Load(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: %s: %w", entry.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migrate: version %d has migrations named %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(body)
+			sum := sha256.Sum256(body)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Checksum == "" {
+			return nil, fmt.Errorf("migrate: version %d has no up migration", m.Version)
+		}
+		migrations = append(migrations, m)
+	}
+	slices.SortFunc(migrations, func(a, b *Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
+
+// Migrator applies migrations to a database.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []*Migration
+	// Log, when set, is called for every migration applied or rolled back.
+	Log func(msg string, args ...any)
+}
+
+// New returns a Migrator for the migrations in fsys.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// Up applies every migration that was not applied yet, in version order, and returns how many
+// were applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	count := 0
+	err := m.locked(ctx, true, func(conn *sql.Conn, applied map[int64]appliedMigration) error {
+		for _, mig := range m.Migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down rolls back the last steps applied migrations, newest first, and returns how many were
+// rolled back.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	count := 0
+	err := m.locked(ctx, true, func(conn *sql.Conn, applied map[int64]appliedMigration) error {
+		for _, version := range sortedVersions(applied, true) {
+			if count == steps {
+				break
+			}
+			if err := m.rollback(ctx, conn, version); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Goto migrates the database to the given version: the migrations up to it are applied and the
+// ones after it are rolled back. Version 0 rolls back every migration.
+func (m *Migrator) Goto(ctx context.Context, version int64) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("%w %d", ErrUnknownVersion, version)
+	}
+
+	return m.locked(ctx, true, func(conn *sql.Conn, applied map[int64]appliedMigration) error {
+		down, up := m.plan(applied, version)
+		for _, v := range down {
+			if err := m.rollback(ctx, conn, v); err != nil {
+				return err
+			}
+		}
+		for _, mig := range up {
+			if err := m.apply(ctx, conn, mig); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// plan returns the applied versions after version to roll back, newest first, and the
+// migrations up to version that are not applied yet, oldest first.
+func (m *Migrator) plan(applied map[int64]appliedMigration, version int64) (down []int64, up []*Migration) {
+	for _, v := range sortedVersions(applied, true) {
+		if v <= version {
+			break
+		}
+		down = append(down, v)
+	}
+	for _, mig := range m.Migrations {
+		if mig.Version > version {
+			break
+		}
+		if _, ok := applied[mig.Version]; !ok {
+			up = append(up, mig)
+		}
+	}
+	return down, up
+}
+
+// Status lists the known and applied migrations, ordered by version.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.locked(ctx, false, func(conn *sql.Conn, applied map[int64]appliedMigration) error {
+		for _, mig := range m.Migrations {
+			st := Status{Version: mig.Version, Name: mig.Name}
+			if a, ok := applied[mig.Version]; ok {
+				st.AppliedAt = a.appliedAt
+				st.Modified = a.checksum != mig.Checksum
+			}
+			statuses = append(statuses, st)
+		}
+		for version, a := range applied {
+			if m.find(version) == nil {
+				statuses = append(statuses, Status{Version: version, Name: a.name, AppliedAt: a.appliedAt, Unknown: true})
+			}
+		}
+		return nil
+	})
+	slices.SortFunc(statuses, func(a, b Status) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return statuses, err
+}
+
+// Version returns the highest applied version, or 0 when none was applied. It does not take the
+// lock, and is cheap enough to call from a readiness check.
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	var version sql.NullInt64
+	err := m.DB.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+	return version.Int64, err
+}
+
+// Latest returns the highest version known to the binary, or 0 when there are no migrations.
+func (m *Migrator) Latest() int64 {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+func (m *Migrator) find(version int64) *Migration {
+	for _, mig := range m.Migrations {
+		if mig.Version == version {
+			return mig
+		}
+	}
+	return nil
+}
+
+// locked runs fn on a connection holding the migration lock, with the applied migrations. With
+// verify, the applied migrations are first checked against their files, so that nothing is
+// migrated on top of a schema that differs from the one the files describe.
+func (m *Migrator) locked(ctx context.Context, verify bool, fn func(conn *sql.Conn, applied map[int64]appliedMigration) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return err
+	}
+	defer func() {
+		// The lock is released with the session if this fails.
+		_, _ = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+	}()
+
+	if err = m.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	if verify {
+		if err = m.verify(applied); err != nil {
+			return err
+		}
+	}
+
+	return fn(conn, applied)
+}
+
+// verify checks the checksums of the applied migrations against their files. Applied versions
+// without a file are left to the caller.
+func (m *Migrator) verify(applied map[int64]appliedMigration) error {
+	var modified []string
+	for _, version := range sortedVersions(applied, false) {
+		if mig := m.find(version); mig != nil && mig.Checksum != applied[version].checksum {
+			modified = append(modified, strconv.FormatInt(version, 10))
+		}
+	}
+	if len(modified) > 0 {
+		return fmt.Errorf("%w: version %s", ErrChecksumMismatch, strings.Join(modified, ", "))
+	}
+	return nil
+}
+
+// ensureTable creates the schema_migrations table. A schema_migrations table left by
+// golang-migrate, which only records the current version, is renamed to
+// schema_migrations_legacy and its versions are taken over, trusting that they match the files.
+func (m *Migrator) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	var legacy bool
+	err := conn.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = 'schema_migrations' AND column_name = 'dirty'
+		)`).Scan(&legacy)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var legacyVersion int64
+	if legacy {
+		var dirty bool
+		err = tx.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&legacyVersion, &dirty)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("migrate: golang-migrate left version %d dirty; fix the schema and its schema_migrations row first", legacyVersion)
+		}
+		if _, err = tx.ExecContext(ctx, `ALTER TABLE schema_migrations RENAME TO schema_migrations_legacy`); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations
+		(
+			version    BIGINT PRIMARY KEY,
+			name       TEXT                        NOT NULL,
+			checksum   TEXT                        NOT NULL,
+			applied_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`)
+	if err != nil {
+		return err
+	}
+
+	for _, mig := range m.Migrations {
+		if mig.Version > legacyVersion {
+			break
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO schema_migrations (version, name, checksum)
+			VALUES ($1, $2, $3)`, mig.Version, mig.Name, mig.Checksum)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var (
+			version int64
+			a       appliedMigration
+		)
+		if err := rows.Scan(&version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// apply runs an up migration and records it in the same transaction.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig *Migration) error {
+	err := inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO schema_migrations (version, name, checksum)
+			VALUES ($1, $2, $3)`, mig.Version, mig.Name, mig.Checksum)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("migrate: applying %d_%s: %w", mig.Version, mig.Name, err)
+	}
+
+	m.log("applied migration", "version", mig.Version, "name", mig.Name)
+	return nil
+}
+
+// rollback runs the down migration of an applied version and forgets it in the same
+// transaction.
+func (m *Migrator) rollback(ctx context.Context, conn *sql.Conn, version int64) error {
+	mig := m.find(version)
+	if mig == nil {
+		return fmt.Errorf("%w %d: cannot roll it back", ErrUnknownVersion, version)
+	}
+	if mig.Down == "" {
+		return fmt.Errorf("migrate: %d_%s has no down migration", mig.Version, mig.Name)
+	}
+
+	err := inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("migrate: rolling back %d_%s: %w", mig.Version, mig.Name, err)
+	}
+
+	m.log("rolled back migration", "version", mig.Version, "name", mig.Name)
+	return nil
+}
+
+func (m *Migrator) log(msg string, args ...any) {
+	if m.Log != nil {
+		m.Log(msg, args...)
+	}
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func sortedVersions(applied map[int64]appliedMigration, descending bool) []int64 {
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	slices.Sort(versions)
+	if descending {
+		slices.Reverse(versions)
+	}
+	return versions
+}
@@ -0,0 +1,205 @@
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func file(body string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(body)}
+}
+
+func checksum(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+func versions(migrations []*Migration) []int64 {
+	v := make([]int64, len(migrations))
+	for i, mig := range migrations {
+		v[i] = mig.Version
+	}
+	return v
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000010_add_index.up.sql":      file("CREATE INDEX"),
+		"000010_add_index.down.sql":    file("DROP INDEX"),
+		"000002_add_column.up.sql":     file("ALTER TABLE"),
+		"000001_create_table.up.sql":   file("CREATE TABLE"),
+		"000001_create_table.down.sql": file("DROP TABLE"),
+		"README.md":                    file("not a migration"),
+		"000003_nested.up.sql/x.sql":   file("a directory"),
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := versions(migrations), []int64{1, 2, 10}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("versions = %v; want %v", got, want)
+	}
+
+	want := &Migration{
+		Version:  1,
+		Name:     "create_table",
+		Up:       "CREATE TABLE",
+		Down:     "DROP TABLE",
+		Checksum: checksum("CREATE TABLE"),
+	}
+	if !reflect.DeepEqual(migrations[0], want) {
+		t.Errorf("migration 1 = %+v; want %+v", migrations[0], want)
+	}
+
+	// A missing down migration is allowed until it is needed.
+	if migrations[1].Down != "" || migrations[1].Up != "ALTER TABLE" {
+		t.Errorf("migration 2 = %+v; want an up migration only", migrations[1])
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		wantErr string
+	}{
+		{
+			name: "down only",
+			fsys: fstest.MapFS{
+				"000001_create_table.up.sql":   file("CREATE TABLE"),
+				"000002_add_column.down.sql":   file("ALTER TABLE"),
+				"000001_create_table.down.sql": file("DROP TABLE"),
+			},
+			wantErr: "version 2 has no up migration",
+		},
+		{
+			name: "mismatched names",
+			fsys: fstest.MapFS{
+				"000001_create_table.up.sql":    file("CREATE TABLE"),
+				"000001_create_movies.down.sql": file("DROP TABLE"),
+			},
+			wantErr: "version 1 has migrations named",
+		},
+		{
+			name: "version out of range",
+			fsys: fstest.MapFS{
+				"99999999999999999999_create_table.up.sql": file("CREATE TABLE"),
+			},
+			wantErr: "value out of range",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.fsys)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load error = %v; want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func newTestMigrator(t *testing.T) *Migrator {
+	t.Helper()
+	migrations, err := Load(fstest.MapFS{
+		"000001_one.up.sql":     file("1 up"),
+		"000001_one.down.sql":   file("1 down"),
+		"000002_two.up.sql":     file("2 up"),
+		"000002_two.down.sql":   file("2 down"),
+		"000003_three.up.sql":   file("3 up"),
+		"000003_three.down.sql": file("3 down"),
+		"000005_five.up.sql":    file("5 up"),
+		"000005_five.down.sql":  file("5 down"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Migrator{Migrations: migrations}
+}
+
+func appliedVersions(m *Migrator, versions ...int64) map[int64]appliedMigration {
+	applied := make(map[int64]appliedMigration)
+	for _, version := range versions {
+		a := appliedMigration{name: "unknown", checksum: "unknown"}
+		if mig := m.find(version); mig != nil {
+			a = appliedMigration{name: mig.Name, checksum: mig.Checksum}
+		}
+		applied[version] = a
+	}
+	return applied
+}
+
+func TestVerify(t *testing.T) {
+	m := newTestMigrator(t)
+
+	if err := m.verify(appliedVersions(m, 1, 2, 3)); err != nil {
+		t.Errorf("verify of unchanged migrations = %v; want nil", err)
+	}
+
+	// An applied version without a file is not a checksum mismatch.
+	if err := m.verify(appliedVersions(m, 1, 4)); err != nil {
+		t.Errorf("verify with an unknown applied version = %v; want nil", err)
+	}
+
+	applied := appliedVersions(m, 1, 2, 3)
+	applied[3] = appliedMigration{name: "three", checksum: checksum("3 up, as first written")}
+	applied[1] = appliedMigration{name: "one", checksum: checksum("1 up, as first written")}
+	err := m.verify(applied)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("verify of edited migrations = %v; want ErrChecksumMismatch", err)
+	}
+	if !strings.HasSuffix(err.Error(), "version 1, 3") {
+		t.Errorf("verify error = %q; want it to list versions 1, 3", err)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	m := newTestMigrator(t)
+
+	tests := []struct {
+		name     string
+		applied  []int64
+		version  int64
+		wantDown []int64
+		wantUp   []int64
+	}{
+		{"fresh database to latest", nil, 5, nil, []int64{1, 2, 3, 5}},
+		{"fresh database to a middle version", nil, 2, nil, []int64{1, 2}},
+		{"already there", []int64{1, 2, 3}, 3, nil, nil},
+		{"forward", []int64{1, 2}, 5, nil, []int64{3, 5}},
+		{"back, newest first", []int64{1, 2, 3, 5}, 1, []int64{5, 3, 2}, nil},
+		{"to zero", []int64{1, 2, 3}, 0, []int64{3, 2, 1}, nil},
+		{"gap is filled", []int64{1, 3}, 3, nil, []int64{2}},
+		{"gap above the target is rolled back", []int64{1, 3}, 2, []int64{3}, []int64{2}},
+		{"unknown applied version is rolled back", []int64{1, 2, 4}, 3, []int64{4}, []int64{3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			down, up := m.plan(appliedVersions(m, tt.applied...), tt.version)
+			if !slices.Equal(down, tt.wantDown) {
+				t.Errorf("rollbacks = %v; want %v", down, tt.wantDown)
+			}
+			if got := versions(up); !slices.Equal(got, tt.wantUp) {
+				t.Errorf("applies = %v; want %v", got, tt.wantUp)
+			}
+		})
+	}
+}
+
+func TestGotoUnknownVersion(t *testing.T) {
+	m := newTestMigrator(t)
+
+	// The version is checked before the database is touched, so no database is needed.
+	if err := m.Goto(t.Context(), 4); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Goto(4) = %v; want ErrUnknownVersion", err)
+	}
+}
@@ -0,0 +1,92 @@
+package moviesio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	"github.com/yanglyu520/movies-golang-web-api/internal/validator"
+)
+
+// Import validates the movies of reader and, unless dryRun, stores the valid ones with insert,
+// in batches of BatchSize. A batch that cannot be inserted rejects its rows and the import goes
+// on with the next one. Reading stops at the first error that is not a *RowError, or when ctx is
+// done; the rows handled before are still stored and reported, and Report.Error says why the
+// import stopped. logError, when not nil, is called with every insert and read error.
+func Import(ctx context.Context, reader Reader, dryRun bool, insert func(movies []*data.Movie) error, logError func(err error)) *Report {
+	report := &Report{DryRun: dryRun, Rows: []Row{}}
+
+	var (
+		batch      []*data.Movie
+		batchLines []int
+	)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		err := insert(batch)
+		for i, movie := range batch {
+			if err != nil {
+				report.Reject(batchLines[i], map[string]string{"database": "the batch containing this row could not be inserted"})
+				continue
+			}
+			report.Accept(batchLines[i], movie.ID)
+		}
+		if err != nil && logError != nil {
+			logError(err)
+		}
+
+		batch, batchLines = batch[:0], batchLines[:0]
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			report.Error = err.Error()
+			break
+		}
+
+		line, movie, err := reader.Next()
+		if err != nil {
+			var re *RowError
+			switch {
+			case errors.Is(err, io.EOF):
+			case errors.As(err, &re):
+				report.Reject(re.Line, re.Errors)
+				continue
+			default:
+				if logError != nil {
+					logError(err)
+				}
+				report.Error = fmt.Sprintf("reading line %d: %v", line+1, err)
+			}
+			break
+		}
+
+		movie.CreatedAt = time.Now().Unix()
+
+		v := validator.New()
+		if data.ValidateMovie(v, movie); !v.Valid() {
+			report.Reject(line, v.Errors)
+			continue
+		}
+
+		if dryRun {
+			report.Accept(line, 0)
+			continue
+		}
+
+		batch = append(batch, movie)
+		batchLines = append(batchLines, line)
+		if len(batch) == BatchSize {
+			flush()
+		}
+	}
+	flush()
+
+	report.Sort()
+	return report
+}
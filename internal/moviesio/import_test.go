@@ -0,0 +1,156 @@
+package moviesio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+)
+
+// importCSV builds a CSV import of n valid movies, with the row of every line in invalid
+// replaced by an invalid one.
+func importCSV(n int, invalid ...int) string {
+	var b strings.Builder
+	b.WriteString("title,year,runtime,genres\n")
+	for line := 2; line < n+2; line++ {
+		row := fmt.Sprintf("Movie %d,1999,100,drama|comedy\n", line)
+		for _, l := range invalid {
+			if l == line {
+				row = "Movie,1800,100,drama\n"
+			}
+		}
+		b.WriteString(row)
+	}
+	return b.String()
+}
+
+// fakeInsert assigns IDs like the database would, and fails the batches numbered in fail.
+func fakeInsert(calls *int, fail ...int) func(movies []*data.Movie) error {
+	var id int64
+	return func(movies []*data.Movie) error {
+		*calls++
+		for _, f := range fail {
+			if f == *calls {
+				return errors.New("insert failed")
+			}
+		}
+		for _, movie := range movies {
+			id++
+			movie.ID = id
+		}
+		return nil
+	}
+}
+
+func newTestCSVReader(t *testing.T, csv string) *CSVReader {
+	t.Helper()
+	reader, err := NewCSVReader(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+func TestImport(t *testing.T) {
+	var calls int
+	reader := newTestCSVReader(t, importCSV(BatchSize+2, 3))
+
+	report := Import(context.Background(), reader, false, fakeInsert(&calls), nil)
+
+	if calls != 2 {
+		t.Errorf("insert called %d times; want 2", calls)
+	}
+	if report.Accepted != BatchSize+1 || report.Rejected != 1 || report.Error != "" {
+		t.Fatalf("report = %d accepted, %d rejected, error %q; want %d, 1, none", report.Accepted, report.Rejected, report.Error, BatchSize+1)
+	}
+	for i, row := range report.Rows {
+		if row.Line != i+2 {
+			t.Fatalf("row %d is line %d; want the rows in line order", i, row.Line)
+		}
+	}
+	if row := report.Rows[1]; row.Status != "rejected" || row.Errors["year"] == "" {
+		t.Errorf("line 3 = %+v; want it rejected for its year", row)
+	}
+}
+
+func TestImportFailedBatch(t *testing.T) {
+	var (
+		calls  int
+		logged []error
+	)
+	reader := newTestCSVReader(t, importCSV(2*BatchSize+1))
+
+	report := Import(context.Background(), reader, false, fakeInsert(&calls, 1), func(err error) {
+		logged = append(logged, err)
+	})
+
+	// The first batch fails, and the import goes on with the next ones.
+	if calls != 3 {
+		t.Errorf("insert called %d times; want 3", calls)
+	}
+	if report.Rejected != BatchSize || report.Accepted != BatchSize+1 {
+		t.Errorf("report = %d accepted, %d rejected; want %d, %d", report.Accepted, report.Rejected, BatchSize+1, BatchSize)
+	}
+	if report.Rows[0].Errors["database"] == "" {
+		t.Errorf("line 2 = %+v; want it rejected by the database", report.Rows[0])
+	}
+	if report.Error != "" {
+		t.Errorf("report.Error = %q; want none for a failed batch", report.Error)
+	}
+	if len(logged) != 1 {
+		t.Errorf("logged %d errors; want 1", len(logged))
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	var calls int
+	reader := newTestCSVReader(t, importCSV(3, 4))
+
+	report := Import(context.Background(), reader, true, fakeInsert(&calls), nil)
+
+	if calls != 0 {
+		t.Errorf("insert called %d times on a dry run", calls)
+	}
+	if !report.DryRun || report.Accepted != 2 || report.Rejected != 1 {
+		t.Errorf("report = %+v; want a dry run with 2 accepted and 1 rejected", report)
+	}
+}
+
+func TestImportReadError(t *testing.T) {
+	var calls int
+	body := io.MultiReader(strings.NewReader(importCSV(2)), iotest.ErrReader(errors.New("connection reset")))
+	reader, err := NewCSVReader(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := Import(context.Background(), reader, false, fakeInsert(&calls), nil)
+
+	// The rows read before the error are still inserted.
+	if calls != 1 || report.Accepted != 2 {
+		t.Errorf("insert called %d times, %d accepted; want 1, 2", calls, report.Accepted)
+	}
+	if report.Error != "reading line 4: connection reset" {
+		t.Errorf("report.Error = %q; want the read error on line 4", report.Error)
+	}
+}
+
+func TestImportCanceled(t *testing.T) {
+	var calls int
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report := Import(ctx, newTestCSVReader(t, importCSV(2)), false, fakeInsert(&calls), nil)
+
+	if calls != 0 || len(report.Rows) != 0 {
+		t.Errorf("insert called %d times, %d rows; want nothing imported", calls, len(report.Rows))
+	}
+	if report.Error != context.Canceled.Error() {
+		t.Errorf("report.Error = %q; want %q", report.Error, context.Canceled)
+	}
+}
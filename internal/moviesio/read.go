@@ -0,0 +1,163 @@
+// Package moviesio reads movie import files, in CSV or NDJSON, imports them in batches and
+// reports the outcome row by row. It is shared by the import endpoint of the API and by moviesctl, so that a
+// file imports the same way through either.
+package moviesio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	"github.com/yanglyu520/movies-golang-web-api/internal/validator"
+)
+
+// BatchSize is the number of valid rows inserted per transaction.
+const BatchSize = 500
+
+// ListSeparator separates the items of a list held in a single CSV cell, such as the genres of
+// a movie. Exports use it too, so that they can be imported again.
+const ListSeparator = "|"
+
+// MaxLineSize is the size of the longest NDJSON line read. A single movie is never larger than
+// a regular request body.
+const MaxLineSize = 1024 * 1024
+
+// Columns are the CSV columns an import must provide, in any order.
+var Columns = []string{"title", "year", "runtime", "genres"}
+
+// Reader yields the movies of an import file one row at a time.
+type Reader interface {
+	// Next returns the next movie and the line it started on. It returns a *RowError for a row
+	// that cannot be parsed, after which reading can continue, and io.EOF after the last row.
+	// The movie is not validated.
+	Next() (int, *data.Movie, error)
+}
+
+// RowError reports a row that cannot be parsed.
+type RowError struct {
+	Line   int
+	Errors map[string]string
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d is invalid", e.Line)
+}
+
+// NDJSONReader reads one JSON movie per line, skipping blank lines.
+type NDJSONReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func NewNDJSONReader(r io.Reader) *NDJSONReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineSize)
+	return &NDJSONReader{scanner: scanner}
+}
+
+func (mr *NDJSONReader) Next() (int, *data.Movie, error) {
+	for mr.scanner.Scan() {
+		mr.line++
+
+		line := bytes.TrimSpace(mr.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var input struct {
+			Title   string   `json:"title"`
+			Year    int32    `json:"year"`
+			Runtime int32    `json:"runtime"`
+			Genres  []string `json:"genres"`
+		}
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&input); err != nil {
+			return mr.line, nil, &RowError{Line: mr.line, Errors: map[string]string{"json": err.Error()}}
+		}
+		return mr.line, &data.Movie{Title: input.Title, Year: input.Year, Runtime: input.Runtime, Genres: input.Genres}, nil
+	}
+
+	if err := mr.scanner.Err(); err != nil {
+		return mr.line, nil, err
+	}
+	return mr.line, nil, io.EOF
+}
+
+// CSVReader reads movies from CSV with a header row naming the Columns.
+type CSVReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+	line    int
+}
+
+// NewCSVReader reads the header row and checks it names exactly the Columns.
+func NewCSVReader(r io.Reader) (*CSVReader, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !validator.PermittedValue(name, Columns...) {
+			return nil, fmt.Errorf("CSV header contains unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	for _, name := range Columns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %q column", name)
+		}
+	}
+
+	return &CSVReader{reader: reader, columns: columns}, nil
+}
+
+func (mr *CSVReader) Next() (int, *data.Movie, error) {
+	record, err := mr.reader.Read()
+
+	var parseError *csv.ParseError
+	switch {
+	case errors.As(err, &parseError):
+		return parseError.StartLine, nil, &RowError{Line: parseError.StartLine, Errors: map[string]string{"csv": parseError.Err.Error()}}
+	case err != nil:
+		return mr.line, nil, err
+	}
+
+	line, _ := mr.reader.FieldPos(0)
+	mr.line = line
+
+	movie := &data.Movie{Title: record[mr.columns["title"]]}
+	if genres := strings.TrimSpace(record[mr.columns["genres"]]); genres != "" {
+		movie.Genres = strings.Split(genres, ListSeparator)
+	}
+
+	errs := map[string]string{}
+	year, err := strconv.ParseInt(strings.TrimSpace(record[mr.columns["year"]]), 10, 32)
+	if err != nil {
+		errs["year"] = "must be an integer value"
+	}
+	runtime, err := strconv.ParseInt(strings.TrimSpace(record[mr.columns["runtime"]]), 10, 32)
+	if err != nil {
+		errs["runtime"] = "must be an integer value"
+	}
+	movie.Year, movie.Runtime = int32(year), int32(runtime)
+
+	if len(errs) > 0 {
+		return line, nil, &RowError{Line: line, Errors: errs}
+	}
+	return line, movie, nil
+}
@@ -0,0 +1,37 @@
+package moviesio
+
+import "sort"
+
+// Row is the outcome of importing one row.
+type Row struct {
+	Line   int               `json:"line"`
+	Status string            `json:"status"`
+	ID     int64             `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Report is the outcome of an import. Error is set when reading the file failed part way, in
+// which case the rows read before are still reported.
+type Report struct {
+	DryRun   bool   `json:"dry_run"`
+	Accepted int    `json:"accepted"`
+	Rejected int    `json:"rejected"`
+	Error    string `json:"error,omitempty"`
+	Rows     []Row  `json:"rows"`
+}
+
+func (rep *Report) Accept(line int, id int64) {
+	rep.Accepted++
+	rep.Rows = append(rep.Rows, Row{Line: line, Status: "accepted", ID: id})
+}
+
+func (rep *Report) Reject(line int, errs map[string]string) {
+	rep.Rejected++
+	rep.Rows = append(rep.Rows, Row{Line: line, Status: "rejected", Errors: errs})
+}
+
+// Sort puts the rows back into line order. Rejected rows are usually reported as soon as they
+// are read, while accepted rows wait for their batch.
+func (rep *Report) Sort() {
+	sort.SliceStable(rep.Rows, func(i, j int) bool { return rep.Rows[i].Line < rep.Rows[j].Line })
+}
@@ -0,0 +1,71 @@
+package seed
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+)
+
+// fixtures are small fixed sets of movies for tests, by name.
+var fixtures = map[string]func() []*data.Movie{
+	// classics are well known movies with their real details.
+	"classics": func() []*data.Movie {
+		return []*data.Movie{
+			{Title: "Casablanca", Year: 1942, Runtime: 102, Genres: []string{"drama", "romance", "war"}},
+			{Title: "Citizen Kane", Year: 1941, Runtime: 119, Genres: []string{"drama", "mystery"}},
+			{Title: "The Godfather", Year: 1972, Runtime: 175, Genres: []string{"crime", "drama"}},
+			{Title: "Seven Samurai", Year: 1954, Runtime: 207, Genres: []string{"action", "drama"}},
+			{Title: "Metropolis", Year: 1927, Runtime: 153, Genres: []string{"drama", "sci-fi"}},
+			{Title: "Spirited Away", Year: 2001, Runtime: 125, Genres: []string{"animation", "adventure", "family", "fantasy"}},
+			{Title: "Pulp Fiction", Year: 1994, Runtime: 154, Genres: []string{"crime", "drama"}},
+			{Title: "Alien", Year: 1979, Runtime: 117, Genres: []string{"horror", "sci-fi"}},
+			{Title: "The Good, the Bad and the Ugly", Year: 1966, Runtime: 178, Genres: []string{"adventure", "western"}},
+			{Title: "Singin' in the Rain", Year: 1952, Runtime: 103, Genres: []string{"comedy", "musical", "romance"}},
+		}
+	},
+	// search holds titles that differ slightly, for title, prefix and fuzzy search tests.
+	"search": func() []*data.Movie {
+		return []*data.Movie{
+			{Title: "The Godfather", Year: 1972, Runtime: 175, Genres: []string{"crime", "drama"}},
+			{Title: "The Godfather Part II", Year: 1974, Runtime: 202, Genres: []string{"crime", "drama"}},
+			{Title: "The Godfather Part III", Year: 1990, Runtime: 162, Genres: []string{"crime", "drama"}},
+			{Title: "Godzilla", Year: 1954, Runtime: 96, Genres: []string{"horror", "sci-fi"}},
+			{Title: "Good Will Hunting", Year: 1997, Runtime: 126, Genres: []string{"drama", "romance"}},
+			{Title: "Amélie", Year: 2001, Runtime: 122, Genres: []string{"comedy", "romance"}},
+			{Title: "Léon: The Professional", Year: 1994, Runtime: 110, Genres: []string{"action", "crime", "drama"}},
+			{Title: "M", Year: 1931, Runtime: 117, Genres: []string{"crime", "mystery", "thriller"}},
+			{Title: "Se7en", Year: 1995, Runtime: 127, Genres: []string{"crime", "mystery", "thriller"}},
+			{Title: "100% Wolf", Year: 2020, Runtime: 96, Genres: []string{"animation", "family"}},
+		}
+	},
+	// boundaries sit at the limits of data.ValidateMovie and the table constraints.
+	"boundaries": func() []*data.Movie {
+		return []*data.Movie{
+			{Title: "Roundhay Garden Scene", Year: MinYear, Runtime: 1, Genres: []string{"documentary"}},
+			{Title: "This Year", Year: int32(time.Now().Year()), Runtime: 90, Genres: []string{"drama"}},
+			{Title: strings.Repeat("x", 500), Year: 2000, Runtime: 100, Genres: []string{"comedy"}},
+			{Title: "Five Genres", Year: 2010, Runtime: 999, Genres: []string{"action", "comedy", "drama", "horror", "war"}},
+		}
+	},
+}
+
+// Fixture returns a fresh copy of the fixture set with the given name, and whether it exists.
+func Fixture(name string) ([]*data.Movie, bool) {
+	fixture, ok := fixtures[name]
+	if !ok {
+		return nil, false
+	}
+	return fixture(), true
+}
+
+// FixtureNames returns the names of the fixture sets, sorted.
+func FixtureNames() []string {
+	names := make([]string, 0, len(fixtures))
+	for name := range fixtures {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,151 @@
+// Package seed generates movies for development databases and load tests. A Generator derives
+// every movie from its seed, so the same seed always produces the same catalog, and fixtures
+// provide small fixed sets for tests.
+package seed
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+)
+
+// MinYear is the earliest release year generated, the first year accepted by data.ValidateMovie.
+const MinYear = 1889
+
+// Genres are the genres generated movies are tagged with.
+var Genres = []string{
+	"action", "adventure", "animation", "biography", "comedy", "crime", "documentary", "drama",
+	"family", "fantasy", "film-noir", "history", "horror", "musical", "mystery", "romance",
+	"sci-fi", "sport", "thriller", "war", "western",
+}
+
+var (
+	adjectives = []string{
+		"Silent", "Last", "Crimson", "Hidden", "Broken", "Endless", "Golden", "Lost", "Midnight",
+		"Burning", "Frozen", "Savage", "Distant", "Electric", "Forgotten", "Wild", "Dark", "Little",
+		"Iron", "Secret", "Hollow", "Restless", "Brave", "Final", "Velvet", "Scarlet", "Quiet",
+		"Wicked", "Shattered", "Eternal",
+	}
+	nouns = []string{
+		"River", "Kingdom", "Stranger", "Garden", "Empire", "Storm", "Shadow", "Horizon", "Heart",
+		"Machine", "City", "Island", "Promise", "Mirror", "Frontier", "Station", "Dream", "Harbor",
+		"Witness", "Season", "Road", "Game", "Crown", "Signal", "Circus", "Orchard", "Lighthouse",
+		"Detective", "Voyage", "Symphony",
+	}
+	names = []string{
+		"Annie", "Marlowe", "Django", "Amelie", "Casey", "Rosa", "Gatsby", "Vera", "Kane", "Ingrid",
+		"Hugo", "Nadia", "Otis", "Beatrix", "Felix", "Juno",
+	}
+	places = []string{
+		"Casablanca", "Paris", "Tokyo", "Brooklyn", "Nebraska", "Havana", "Berlin", "Bombay",
+		"Marrakech", "Alaska", "Vienna", "Chinatown",
+	}
+	sequels = []string{"II", "III", "2", "Returns", "Reloaded", "Part Two", "The Beginning"}
+)
+
+// genreCounts weights the number of genres of a movie, from 1 to 5 as the database requires.
+var genreCounts = []int{30, 35, 22, 9, 4}
+
+// Generator generates realistic looking movies, deterministically from its seed. It is not safe
+// for concurrent use.
+type Generator struct {
+	rng *rand.Rand
+
+	// MaxYear is the latest release year generated, the current year when zero. It must be set
+	// to reproduce a catalog across calendar years.
+	MaxYear int32
+}
+
+// New returns a Generator producing the sequence of movies of seed.
+func New(seed uint64) *Generator {
+	return &Generator{rng: rand.New(rand.NewPCG(seed, seed^0x6d6f76696573))}
+}
+
+// Movie returns the next movie. Its ID, version and creation time are left for the database.
+func (g *Generator) Movie() *data.Movie {
+	return &data.Movie{
+		Title:   g.title(),
+		Year:    g.year(),
+		Runtime: g.runtime(),
+		Genres:  g.genres(),
+	}
+}
+
+// Movies returns the next n movies.
+func (g *Generator) Movies(n int) []*data.Movie {
+	movies := make([]*data.Movie, n)
+	for i := range movies {
+		movies[i] = g.Movie()
+	}
+	return movies
+}
+
+func (g *Generator) pick(words []string) string {
+	return words[g.rng.IntN(len(words))]
+}
+
+func (g *Generator) title() string {
+	var title string
+	switch g.rng.IntN(7) {
+	case 0:
+		title = "The " + g.pick(adjectives) + " " + g.pick(nouns)
+	case 1:
+		title = "The " + g.pick(nouns) + " of " + g.pick(places)
+	case 2:
+		title = g.pick(adjectives) + " " + g.pick(nouns)
+	case 3:
+		title = g.pick(names) + "'s " + g.pick(nouns)
+	case 4:
+		title = g.pick(nouns) + " in " + g.pick(places)
+	case 5:
+		title = "A " + g.pick(nouns) + " for " + g.pick(names)
+	default:
+		title = g.pick(places)
+	}
+
+	if g.rng.IntN(10) == 0 {
+		title += " " + g.pick(sequels)
+	}
+	return title
+}
+
+// year favors recent years, as more movies have been made lately.
+func (g *Generator) year() int32 {
+	maxYear := g.MaxYear
+	if maxYear == 0 {
+		maxYear = int32(time.Now().Year())
+	}
+
+	span := float64(maxYear - MinYear + 1)
+	u := g.rng.Float64()
+	return maxYear - int32(span*u*u)
+}
+
+// runtime is mostly that of a feature film, sometimes a short or an epic.
+func (g *Generator) runtime() int32 {
+	switch n := g.rng.IntN(100); {
+	case n < 5:
+		return 5 + g.rng.Int32N(36)
+	case n < 8:
+		return 150 + g.rng.Int32N(91)
+	}
+
+	runtime := int32(g.rng.NormFloat64()*18 + 105)
+	return min(max(runtime, 60), 180)
+}
+
+func (g *Generator) genres() []string {
+	n := 1
+	for w := g.rng.IntN(100); w >= genreCounts[n-1]; n++ {
+		w -= genreCounts[n-1]
+	}
+
+	// A partial shuffle draws n distinct genres.
+	pool := append([]string(nil), Genres...)
+	for i := range n {
+		j := i + g.rng.IntN(len(pool)-i)
+		pool[i], pool[j] = pool[j], pool[i]
+	}
+	return pool[:n:n]
+}
@@ -0,0 +1,46 @@
+package seed
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yanglyu520/movies-golang-web-api/internal/data"
+	"github.com/yanglyu520/movies-golang-web-api/internal/validator"
+)
+
+func TestMoviesRepeatable(t *testing.T) {
+	const seed, n = 42, 1000
+
+	first := New(seed).Movies(n)
+	second := New(seed).Movies(n)
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("New(%d).Movies(%d) differs between calls", seed, n)
+	}
+
+	if other := New(seed + 1).Movies(n); reflect.DeepEqual(first, other) {
+		t.Fatalf("New(%d) and New(%d) generate the same movies", seed, seed+1)
+	}
+}
+
+func TestMoviesValid(t *testing.T) {
+	for _, seed := range []uint64{0, 1, 42} {
+		for i, movie := range New(seed).Movies(10000) {
+			v := validator.New()
+			if data.ValidateMovie(v, movie); !v.Valid() {
+				t.Fatalf("seed %d, movie %d %+v is invalid: %v", seed, i, movie, v.Errors)
+			}
+		}
+	}
+}
+
+func TestFixturesValid(t *testing.T) {
+	for _, name := range FixtureNames() {
+		movies, _ := Fixture(name)
+		for i, movie := range movies {
+			v := validator.New()
+			if data.ValidateMovie(v, movie); !v.Valid() {
+				t.Errorf("fixture %s, movie %d %q is invalid: %v", name, i, movie.Title, v.Errors)
+			}
+		}
+	}
+}
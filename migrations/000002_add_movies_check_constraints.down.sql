@@ -1,5 +1,5 @@
 ALTER TABLE movies
-DROP CONSTRAINT IF EXISTS movies_runtime_check;
+DROP CONSTRAINT IF EXISTS movies_run_time;
 
 ALTER TABLE movies
 DROP CONSTRAINT IF EXISTS movies_year_check;
@@ -0,0 +1,2 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS movies_title_trgm_idx ON movies USING GIN (title gin_trgm_ops);
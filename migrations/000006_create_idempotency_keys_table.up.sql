@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    key         TEXT PRIMARY KEY,
+    fingerprint TEXT                        NOT NULL,
+    status      INTEGER,
+    headers     JSONB                       NOT NULL DEFAULT '{}',
+    body        BYTEA,
+    created_at  TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at  TIMESTAMP(0) WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
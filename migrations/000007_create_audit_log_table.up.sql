@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id         BIGSERIAL PRIMARY KEY,
+    entity     TEXT                        NOT NULL,
+    entity_id  BIGINT                      NOT NULL,
+    action     TEXT                        NOT NULL,
+    actor      TEXT                        NOT NULL DEFAULT '',
+    request_id TEXT                        NOT NULL DEFAULT '',
+    remote_ip  TEXT                        NOT NULL DEFAULT '',
+    changes    JSONB                       NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity, entity_id, id);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor, id);
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS movie_revisions
+(
+    movie_id   BIGINT                      NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version    INTEGER                     NOT NULL,
+    title      TEXT                        NOT NULL,
+    year       INTEGER                     NOT NULL,
+    runtime    INTEGER                     NOT NULL,
+    genres     TEXT[]                      NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (movie_id, version)
+);
+-- Existing movies start their history at their current version.
+INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres)
+SELECT id, version, title, year, runtime, genres
+FROM movies
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS webhook_subscriptions
+(
+    id         BIGSERIAL PRIMARY KEY,
+    url        TEXT                        NOT NULL,
+    secret     TEXT                        NOT NULL,
+    events     TEXT[]                      NOT NULL DEFAULT '{}',
+    active     BOOLEAN                     NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    version    INTEGER                     NOT NULL DEFAULT 1
+);
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id               BIGSERIAL PRIMARY KEY,
+    subscription_id  BIGINT                      NOT NULL REFERENCES webhook_subscriptions ON DELETE CASCADE,
+    event            TEXT                        NOT NULL,
+    payload          JSONB                       NOT NULL,
+    status           TEXT                        NOT NULL DEFAULT 'pending',
+    attempts         INTEGER                     NOT NULL DEFAULT 0,
+    next_attempt_at  TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_status_code INTEGER,
+    last_error       TEXT                        NOT NULL DEFAULT '',
+    created_at       TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    delivered_at     TIMESTAMP(0) WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_idx ON webhook_deliveries (subscription_id, id);
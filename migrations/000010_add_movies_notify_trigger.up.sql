@@ -0,0 +1,36 @@
+CREATE SEQUENCE IF NOT EXISTS movie_changes_seq;
+
+-- notify_movie_change publishes every change to the movies table on the movie_changes channel,
+-- numbered from movie_changes_seq. Notifications are only sent once the transaction commits.
+CREATE OR REPLACE FUNCTION notify_movie_change() RETURNS trigger AS
+$$
+DECLARE
+    movie movies;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        movie := OLD;
+    ELSE
+        movie := NEW;
+    END IF;
+
+    PERFORM pg_notify('movie_changes', json_build_object(
+            'seq', nextval('movie_changes_seq'),
+            'op', lower(TG_OP),
+            'movie', json_build_object(
+                    'id', movie.id,
+                    'title', movie.title,
+                    'year', movie.year,
+                    'runtime', movie.runtime,
+                    'genres', movie.genres,
+                    'version', movie.version
+                )
+        )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movies_notify_change
+    AFTER INSERT OR UPDATE OR DELETE
+    ON movies
+    FOR EACH ROW
+EXECUTE FUNCTION notify_movie_change();
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS outbox
+(
+    id              BIGSERIAL PRIMARY KEY,
+    aggregate_id    BIGINT                      NOT NULL,
+    event           TEXT                        NOT NULL,
+    payload         JSONB                       NOT NULL,
+    attempts        INTEGER                     NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_error      TEXT                        NOT NULL DEFAULT '',
+    created_at      TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    published_at    TIMESTAMP(0) WITH TIME ZONE
+);
+-- Backs the lookup of the oldest unpublished event of every movie.
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (aggregate_id, id) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_published_at_idx ON outbox (published_at) WHERE published_at IS NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE idempotency_keys
+    ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW();
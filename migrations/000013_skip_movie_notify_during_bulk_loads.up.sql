@@ -0,0 +1,33 @@
+-- notify_movie_change stays silent while the movies.bulk_load setting is on, so that a bulk load
+-- does not flood the movie_changes channel with a notification per row. Bulk loads announce
+-- themselves with a single reset notification instead.
+CREATE OR REPLACE FUNCTION notify_movie_change() RETURNS trigger AS
+$$
+DECLARE
+    movie movies;
+BEGIN
+    IF current_setting('movies.bulk_load', true) = 'on' THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP = 'DELETE' THEN
+        movie := OLD;
+    ELSE
+        movie := NEW;
+    END IF;
+
+    PERFORM pg_notify('movie_changes', json_build_object(
+            'seq', nextval('movie_changes_seq'),
+            'op', lower(TG_OP),
+            'movie', json_build_object(
+                    'id', movie.id,
+                    'title', movie.title,
+                    'year', movie.year,
+                    'runtime', movie.runtime,
+                    'genres', movie.genres,
+                    'version', movie.version
+                )
+        )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;